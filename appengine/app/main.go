@@ -72,7 +72,7 @@ func init() {
 		}
 	}
 
-	http.HandleFunc("/", server.Encode(key, cert))
+	http.Handle("/", server.New(key, cert))
 }
 
 // It seems GAE/Go can not handle environment variables that has
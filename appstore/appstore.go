@@ -0,0 +1,370 @@
+// Package appstore is a mock App Store which keeps track of the
+// transactions of receipts and the notifications sent for them.
+package appstore
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aktsk/kalvados/clock"
+	kreceipt "github.com/aktsk/kalvados/receipt"
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+// ErrTransactionNotFound is returned when a transaction is not known
+// to the store
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// ErrInvalidReason is returned when a cancellation reason is not "0" or "1"
+var ErrInvalidReason = errors.New("cancellation reason must be \"0\" or \"1\"")
+
+// Cancellation reasons
+// https://developer.apple.com/documentation/appstorereceipts/cancellation_reason
+const (
+	CancellationReasonOther    = "0"
+	CancellationReasonAppIssue = "1"
+)
+
+// firstID is the first transaction_id and web_order_line_item_id the
+// store issues
+const firstID = 1000000000000001
+
+// Store is a mock App Store
+type Store struct {
+	Clock *clock.Clock
+
+	// Notify is called with every notification the store sends
+	Notify func(*Notification)
+
+	mu            sync.Mutex
+	transactions  map[string]*Transaction
+	products      map[string]*Product
+	subscriptions map[string]*Subscription
+	families      map[string]*Family
+	askToBuy      map[string]*PendingPurchase
+	redeemed      map[redemption]bool
+	billing       Billing
+	lastID        int64
+	notifications []*Notification
+	pending       []*Notification
+}
+
+// New returns a store which uses c as the current time
+func New(c *clock.Clock) *Store {
+	return &Store{
+		Clock:         c,
+		transactions:  map[string]*Transaction{},
+		products:      map[string]*Product{},
+		subscriptions: map[string]*Subscription{},
+		families:      map[string]*Family{},
+		askToBuy:      map[string]*PendingPurchase{},
+		redeemed:      map[redemption]bool{},
+		lastID:        firstID - 1,
+	}
+}
+
+// lock locks the store and brings subscriptions up to date with the
+// clock
+func (s *Store) lock() {
+	s.mu.Lock()
+	s.update(s.Clock.Now())
+}
+
+// unlock unlocks the store and sends notifications queued while it was
+// locked
+func (s *Store) unlock() {
+	s.syncSharedTransactions()
+
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if s.Notify == nil {
+		return
+	}
+	for _, n := range pending {
+		s.Notify(n)
+	}
+}
+
+// Update brings subscriptions up to date with the clock. Operations
+// of the store do it implicitly, so it is only needed to send
+// notifications right after the clock is changed.
+func (s *Store) Update() {
+	s.lock()
+	s.unlock()
+}
+
+// Record remembers the in-app purchase transactions of a receipt
+func (s *Store) Record(r *receipt.Receipt) {
+	s.lock()
+	defer s.unlock()
+
+	for _, inApp := range r.InApp {
+		if _, ok := s.transactions[inApp.TransactionID]; ok {
+			continue
+		}
+		s.transactions[inApp.TransactionID] = newTransaction(r.BundleID, inApp)
+	}
+}
+
+// Apply updates the in-app purchase transactions of a receipt with
+// the state of the store
+func (s *Store) Apply(r *receipt.Receipt) error {
+	s.lock()
+	defer s.unlock()
+
+	return s.apply(r)
+}
+
+func (s *Store) apply(r *receipt.Receipt) error {
+	for i, inApp := range r.InApp {
+		t, ok := s.transactions[inApp.TransactionID]
+		if !ok {
+			continue
+		}
+
+		updated, err := t.InApp()
+		if err != nil {
+			return err
+		}
+		r.InApp[i] = updated
+	}
+
+	return nil
+}
+
+// Receipt returns a receipt which has all the transactions of a
+// bundle ID for the default account
+func (s *Store) Receipt(bundleID string) (*receipt.Receipt, error) {
+	return s.ReceiptFor("", bundleID)
+}
+
+// ReceiptFor returns a receipt which has all the transactions of a
+// bundle ID for an account
+func (s *Store) ReceiptFor(accountID, bundleID string) (*receipt.Receipt, error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.receipt(accountID, bundleID)
+}
+
+func (s *Store) receipt(accountID, bundleID string) (*receipt.Receipt, error) {
+	r := &receipt.Receipt{
+		ReceiptType:                "ProductionSandbox",
+		BundleID:                   bundleID,
+		ApplicationVersion:         "1",
+		OriginalApplicationVersion: "1.0",
+		InApp:                      []*receipt.InApp{},
+	}
+
+	transactions := s.transactionsOf(accountID, bundleID)
+	for i := len(transactions) - 1; i >= 0; i-- {
+		if s.isConsumed(transactions[i]) {
+			continue
+		}
+		inApp, err := transactions[i].InApp()
+		if err != nil {
+			return nil, err
+		}
+		r.InApp = append(r.InApp, inApp)
+	}
+
+	now := s.Clock.Now()
+	if err := kreceipt.SetDate(&r.CreationDate.Date, now); err != nil {
+		return nil, err
+	}
+
+	originalPurchaseDate := now
+	if len(transactions) > 0 {
+		originalPurchaseDate = transactions[len(transactions)-1].PurchaseDate
+	}
+	if err := kreceipt.SetDate(&r.OriginalPurchaseDate.Date, originalPurchaseDate); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Verify returns a verifyReceipt style response for a receipt, with
+// the state of the store applied
+func (s *Store) Verify(r *receipt.Receipt) (*kreceipt.Response, error) {
+	s.lock()
+	defer s.unlock()
+
+	if err := s.apply(r); err != nil {
+		return nil, err
+	}
+
+	res := kreceipt.NewResponse(kreceipt.FromNolmandy(r), s.Clock.Now())
+
+	// Some fields like is_upgraded are not in receipts
+	inApps := []*kreceipt.ResponseInApp{}
+	for i, inApp := range r.InApp {
+		t, ok := s.transactions[inApp.TransactionID]
+		if !ok {
+			inApps = append(inApps, res.Receipt.InApp[i])
+			continue
+		}
+		if !s.isConsumed(t) {
+			inApps = append(inApps, t.responseInApp())
+		}
+	}
+	res.Receipt.InApp = inApps
+
+	accountID := s.accountOf(r)
+
+	if info := s.latestReceiptInfo(accountID, r.BundleID); len(info) > 0 {
+		res.LatestReceiptInfo = info
+	}
+
+	if renewal := s.pendingRenewalInfo(accountID, r.BundleID); len(renewal) > 0 {
+		res.PendingRenewalInfo = renewal
+	}
+
+	return res, nil
+}
+
+// Transaction returns a transaction known to the store
+func (s *Store) Transaction(transactionID string) (*Transaction, bool) {
+	s.lock()
+	defer s.unlock()
+
+	t, ok := s.transactions[transactionID]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *t
+	return &copied, true
+}
+
+// Refund refunds a transaction and sends a REFUND notification
+func (s *Store) Refund(transactionID, reason string) (*Notification, error) {
+	return s.cancel(transactionID, reason, NotificationTypeRefund)
+}
+
+// Revoke revokes a transaction and sends a REVOKE notification
+func (s *Store) Revoke(transactionID, reason string) (*Notification, error) {
+	return s.cancel(transactionID, reason, NotificationTypeRevoke)
+}
+
+// Finish finishes a transaction like SKPaymentQueue.finishTransaction.
+// Finished consumable transactions are removed from receipts.
+func (s *Store) Finish(transactionID string) error {
+	s.lock()
+	defer s.unlock()
+
+	t, ok := s.transactions[transactionID]
+	if !ok {
+		return ErrTransactionNotFound
+	}
+
+	t.Finished = true
+
+	return nil
+}
+
+// isConsumed returns whether a transaction is a finished consumable,
+// which the App Store removes from receipts
+func (s *Store) isConsumed(t *Transaction) bool {
+	if !t.Finished {
+		return false
+	}
+
+	p, ok := s.products[t.ProductID]
+	return ok && p.productType() == ProductTypeConsumable
+}
+
+// Notifications returns the notifications sent by the store
+func (s *Store) Notifications() []*Notification {
+	s.lock()
+	defer s.unlock()
+
+	return append([]*Notification{}, s.notifications...)
+}
+
+func (s *Store) cancel(transactionID, reason, notificationType string) (*Notification, error) {
+	if reason == "" {
+		reason = CancellationReasonOther
+	}
+	if reason != CancellationReasonOther && reason != CancellationReasonAppIssue {
+		return nil, ErrInvalidReason
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	t, ok := s.transactions[transactionID]
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+
+	t.CancellationDate = s.Clock.Now()
+	t.CancellationReason = reason
+
+	return s.notify(notificationType, t.AccountID, t.BundleID, t.OriginalTransactionID), nil
+}
+
+// nextID returns a new ID for transaction_id and
+// web_order_line_item_id
+func (s *Store) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *Store) newTransactionID() string {
+	return strconv.FormatInt(s.nextID(), 10)
+}
+
+// accountOf returns the account of the transactions of a receipt
+func (s *Store) accountOf(r *receipt.Receipt) string {
+	for _, inApp := range r.InApp {
+		if t, ok := s.transactions[inApp.TransactionID]; ok {
+			return t.AccountID
+		}
+	}
+	return ""
+}
+
+// transactionsOf returns the transactions of a bundle ID for an
+// account, the newest first
+func (s *Store) transactionsOf(accountID, bundleID string) []*Transaction {
+	transactions := []*Transaction{}
+	for _, t := range s.transactions {
+		if t.AccountID == accountID && t.BundleID == bundleID {
+			transactions = append(transactions, t)
+		}
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].PurchaseDate.Equal(transactions[j].PurchaseDate) {
+			return kreceipt.LessTransactionID(transactions[j].TransactionID, transactions[i].TransactionID)
+		}
+		return transactions[i].PurchaseDate.After(transactions[j].PurchaseDate)
+	})
+
+	return transactions
+}
+
+func (s *Store) latestReceiptInfo(accountID, bundleID string) []*kreceipt.ResponseInApp {
+	info := []*kreceipt.ResponseInApp{}
+	for _, t := range s.transactionsOf(accountID, bundleID) {
+		if !s.isConsumed(t) {
+			info = append(info, t.responseInApp())
+		}
+	}
+
+	return info
+}
+
+func (s *Store) pendingRenewalInfo(accountID, bundleID string) []*kreceipt.PendingRenewalInfo {
+	info := []*kreceipt.PendingRenewalInfo{}
+	for _, sub := range s.subscriptionsOf(accountID, bundleID) {
+		info = append(info, sub.pendingRenewalInfo())
+	}
+
+	return info
+}
@@ -0,0 +1,166 @@
+package appstore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aktsk/kalvados/clock"
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+func TestRefund(t *testing.T) {
+	c := clock.New()
+	c.Freeze()
+	c.Set(time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC))
+
+	store := New(c)
+
+	var notified *Notification
+	store.Notify = func(n *Notification) {
+		notified = n
+	}
+
+	rcpt := receipt.Receipt{}
+	if err := json.Unmarshal([]byte(receiptJSON), &rcpt); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Record(&rcpt)
+
+	if _, err := store.Refund("unknown", CancellationReasonAppIssue); err != ErrTransactionNotFound {
+		t.Fatalf("Unknown transaction should not be refunded: %v", err)
+	}
+
+	if _, err := store.Refund("220000359893979", "2"); err != ErrInvalidReason {
+		t.Fatalf("Invalid reason should not be accepted: %v", err)
+	}
+
+	n, err := store.Refund("220000359893979", CancellationReasonAppIssue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.NotificationType != NotificationTypeRefund {
+		t.Fatalf("Wrong notification_type: %s", n.NotificationType)
+	}
+
+	if notified != n {
+		t.Fatal("Notification was not sent")
+	}
+
+	if n.NotificationDate != "2018-04-01 00:00:00 Etc/GMT" || n.NotificationDateMS != "1522540800000" {
+		t.Fatalf("Wrong notification_date: %s, %s", n.NotificationDate, n.NotificationDateMS)
+	}
+
+	info := n.UnifiedReceipt.LatestReceiptInfo
+	if len(info) != 3 || info[1].TransactionID != "220000359893979" {
+		t.Fatalf("Wrong latest_receipt_info: %v", info)
+	}
+
+	if info[1].CancellationDate != "2018-04-01 00:00:00 Etc/GMT" || info[1].CancellationReason != "1" {
+		t.Fatalf("Wrong cancellation: %s, %s", info[1].CancellationDate, info[1].CancellationReason)
+	}
+
+	next := receipt.Receipt{}
+	if err := json.Unmarshal([]byte(receiptJSON), &next); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Apply(&next); err != nil {
+		t.Fatal(err)
+	}
+
+	cancellationDate := time.Time(next.InApp[1].CancellationDate.Date)
+	if !cancellationDate.Equal(c.Now()) {
+		t.Fatalf("Wrong cancellation_date: %v", cancellationDate)
+	}
+
+	if next.InApp[1].CancellationReason != "1" {
+		t.Fatalf("Wrong cancellation_reason: %s", next.InApp[1].CancellationReason)
+	}
+
+	if !time.Time(next.InApp[0].CancellationDate.Date).IsZero() {
+		t.Fatal("Other transactions should not be cancelled")
+	}
+
+	c.Advance(time.Hour)
+
+	if _, err := store.Revoke("220000350729970", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	notifications := store.Notifications()
+	if len(notifications) != 2 || notifications[1].NotificationType != NotificationTypeRevoke {
+		t.Fatalf("Wrong notifications: %v", notifications)
+	}
+
+	if notifications[0].NotificationDate != "2018-04-01 00:00:00 Etc/GMT" || notifications[1].NotificationDate != "2018-04-01 01:00:00 Etc/GMT" {
+		t.Fatalf("Notifications should be dated when queued: %s, %s", notifications[0].NotificationDate, notifications[1].NotificationDate)
+	}
+}
+
+var receiptJSON = `
+{
+  "receipt_type": "ProductionSandbox",
+  "adam_id": 0,
+  "app_item_id": 0,
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "application_version": "51",
+  "download_id": 0,
+  "version_external_identifier": 0,
+  "original_application_version": "49",
+  "in_app": [
+    {
+      "quantity": "0",
+      "product_id": "jp.aktsk.kalvados.test.iap0",
+      "transaction_id": "220000350729970",
+      "original_transaction_id": "220000348788557",
+      "web_order_line_item_id": 220000071891787,
+      "is_trial_period": "false",
+      "purchase_date": "2017-07-24 03:17:15 Etc/GMT",
+      "purchase_date_ms": "1500866235000",
+      "purchase_date_pst": "2017-07-23 20:17:15 America/Los_Angeles",
+      "original_purchase_date": "2017-07-17 03:17:16 Etc/GMT",
+      "original_purchase_date_ms": "1500261436000",
+      "original_purchase_date_pst": "2017-07-16 20:17:16 America/Los_Angeles"
+    },
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.iap1",
+      "transaction_id": "220000359893979",
+      "original_transaction_id": "220000348788557",
+      "web_order_line_item_id": 220000072586770,
+      "is_trial_period": "false",
+      "purchase_date": "2017-08-24 03:17:15 Etc/GMT",
+      "purchase_date_ms": "1503544635000",
+      "purchase_date_pst": "2017-08-23 20:17:15 America/Los_Angeles",
+      "original_purchase_date": "2017-07-17 03:17:16 Etc/GMT",
+      "original_purchase_date_ms": "1500261436000",
+      "original_purchase_date_pst": "2017-07-16 20:17:16 America/Los_Angeles"
+    },
+    {
+      "quantity": "2",
+      "product_id": "jp.aktsk.kalvados.test.iap2",
+      "transaction_id": "220000368932558",
+      "original_transaction_id": "220000348788557",
+      "web_order_line_item_id": 220000075821143,
+      "is_trial_period": "false",
+      "purchase_date": "2017-09-24 03:17:15 Etc/GMT",
+      "purchase_date_ms": "1506223035000",
+      "purchase_date_pst": "2017-09-23 20:17:15 America/Los_Angeles",
+      "original_purchase_date": "2017-07-17 03:17:16 Etc/GMT",
+      "original_purchase_date_ms": "1500261436000",
+      "original_purchase_date_pst": "2017-07-16 20:17:16 America/Los_Angeles"
+    }
+  ],
+  "receipt_creation_date": "2018-02-10 17:37:00 Etc/GMT",
+  "receipt_creation_date_ms": "1518284220000",
+  "receipt_creation_date_pst": "2018-02-10 09:37:00 America/Los_Angeles",
+  "request_date": "2018-03-26 12:00:27 Etc/GMT",
+  "request_date_ms": "1522065627000",
+  "request_date_pst": "2018-03-26 05:00:27 America/Los_Angeles",
+  "original_purchase_date": "2017-07-07 15:36:07 Etc/GMT",
+  "original_purchase_date_ms": "1499441767000",
+  "original_purchase_date_pst": "2017-07-07 08:36:07 America/Los_Angeles"
+}`
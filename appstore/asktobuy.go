@@ -0,0 +1,109 @@
+package appstore
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrPendingPurchaseNotFound is returned when a purchase waiting for
+// approval is not known to the store
+var ErrPendingPurchaseNotFound = errors.New("pending purchase not found")
+
+// PendingPurchase is a purchase with Ask to Buy waiting for approval.
+// It has no transaction until it is approved.
+type PendingPurchase struct {
+	ID          string    `json:"pending_purchase_id"`
+	AccountID   string    `json:"account_id,omitempty"`
+	BundleID    string    `json:"bundle_id"`
+	ProductID   string    `json:"product_id"`
+	RequestDate time.Time `json:"request_date"`
+
+	options purchaseOptions
+}
+
+// RequestPurchase requests a purchase with Ask to Buy. The purchase is
+// deferred until it is approved or declined, and no notification is
+// sent.
+func (s *Store) RequestPurchase(bundleID, productID string, opts ...PurchaseOption) (*PendingPurchase, error) {
+	o := purchaseOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.products[productID]; !ok {
+		return nil, ErrProductNotFound
+	}
+
+	pp := &PendingPurchase{
+		ID:          strconv.FormatInt(s.nextID(), 10),
+		AccountID:   o.accountID,
+		BundleID:    bundleID,
+		ProductID:   productID,
+		RequestDate: s.Clock.Now(),
+		options:     o,
+	}
+	s.askToBuy[pp.ID] = pp
+
+	copied := *pp
+	return &copied, nil
+}
+
+// PendingPurchases returns the purchases waiting for approval
+func (s *Store) PendingPurchases() []PendingPurchase {
+	s.lock()
+	defer s.unlock()
+
+	purchases := []PendingPurchase{}
+	for _, pp := range s.askToBuy {
+		purchases = append(purchases, *pp)
+	}
+
+	sort.Slice(purchases, func(i, j int) bool {
+		return purchases[i].ID < purchases[j].ID
+	})
+
+	return purchases
+}
+
+// Approve approves a pending purchase. The product is purchased at the
+// time of approval, and notifications are sent as for purchases
+// without Ask to Buy: INITIAL_BUY for subscriptions and none for other
+// product types.
+func (s *Store) Approve(id string) (*Transaction, error) {
+	s.lock()
+	defer s.unlock()
+
+	pp, ok := s.askToBuy[id]
+	if !ok {
+		return nil, ErrPendingPurchaseNotFound
+	}
+
+	t, err := s.purchase(pp.BundleID, pp.ProductID, &pp.options)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(s.askToBuy, id)
+
+	return t, nil
+}
+
+// Decline declines a pending purchase. No transaction is made, and no
+// notification is sent.
+func (s *Store) Decline(id string) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.askToBuy[id]; !ok {
+		return ErrPendingPurchaseNotFound
+	}
+
+	delete(s.askToBuy, id)
+
+	return nil
+}
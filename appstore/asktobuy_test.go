@@ -0,0 +1,97 @@
+package appstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAskToBuy(t *testing.T) {
+	store := newTestStore(t)
+
+	approved, err := store.RequestPurchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	declined, err := store.RequestPurchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := store.PendingPurchases(); len(pending) != 2 {
+		t.Fatalf("Wrong number of pending purchases: %d", len(pending))
+	}
+
+	rcpt, err := store.Receipt(testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rcpt.InApp) != 0 {
+		t.Fatal("Pending purchase should not be in receipts")
+	}
+
+	if types := notificationTypes(store); len(types) != 0 {
+		t.Fatalf("Requests should not send notifications: %v", types)
+	}
+
+	if err := store.Decline(declined.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if types := notificationTypes(store); len(types) != 0 {
+		t.Fatalf("Declines should not send notifications: %v", types)
+	}
+
+	store.Clock.Advance(24 * time.Hour)
+
+	purchased, err := store.Approve(approved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !purchased.PurchaseDate.Equal(time.Date(2018, 4, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong purchase_date: %v", purchased.PurchaseDate)
+	}
+
+	rcpt, err = store.Receipt(testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rcpt.InApp) != 1 || rcpt.InApp[0].TransactionID != purchased.TransactionID {
+		t.Fatal("Approved purchase should be in receipts")
+	}
+
+	types := notificationTypes(store)
+	if len(types) != 1 || types[0] != NotificationTypeInitialBuy {
+		t.Fatalf("Wrong notifications: %v", types)
+	}
+
+	if _, err := store.Approve(declined.ID); err != ErrPendingPurchaseNotFound {
+		t.Fatalf("Declined purchase should not be approved: %v", err)
+	}
+
+	if pending := store.PendingPurchases(); len(pending) != 0 {
+		t.Fatalf("Wrong number of pending purchases: %d", len(pending))
+	}
+}
+
+func TestAskToBuyConsumable(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.AddProduct(Product{ProductID: "coins", Type: ProductTypeConsumable}); err != nil {
+		t.Fatal(err)
+	}
+
+	pp, err := store.RequestPurchase(testBundleID, "coins")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Approve(pp.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if types := notificationTypes(store); len(types) != 0 {
+		t.Fatalf("Consumables should not send notifications: %v", types)
+	}
+}
@@ -0,0 +1,155 @@
+package appstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	kreceipt "github.com/aktsk/kalvados/receipt"
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+// ErrInvalidProductType is returned when a product type is unknown, or
+// does not match the subscription period or Family Sharing of the
+// product
+var ErrInvalidProductType = errors.New("product type must be consumable, non_consumable, auto_renewable or non_renewing")
+
+// Product types
+const (
+	ProductTypeConsumable    = "consumable"
+	ProductTypeNonConsumable = "non_consumable"
+	ProductTypeAutoRenewable = "auto_renewable"
+	ProductTypeNonRenewing   = "non_renewing"
+)
+
+// productType returns the type of the product. A product without a
+// type is an auto-renewable subscription if it has a subscription
+// period, and a non-consumable otherwise.
+func (p *Product) productType() string {
+	if p.Type != "" {
+		return p.Type
+	}
+	if p.SubscriptionPeriod != "" {
+		return ProductTypeAutoRenewable
+	}
+	return ProductTypeNonConsumable
+}
+
+// validateType returns an error if the type of the product does not
+// match its subscription period, or is consumable and family shareable
+func (p *Product) validateType() error {
+	switch p.productType() {
+	case ProductTypeAutoRenewable:
+		if p.SubscriptionPeriod == "" {
+			return ErrInvalidProductType
+		}
+	case ProductTypeNonRenewing:
+	case ProductTypeConsumable:
+		if p.SubscriptionPeriod != "" || p.FamilyShareable {
+			return ErrInvalidProductType
+		}
+	case ProductTypeNonConsumable:
+		if p.SubscriptionPeriod != "" {
+			return ErrInvalidProductType
+		}
+	default:
+		return ErrInvalidProductType
+	}
+	return nil
+}
+
+// Catalog is a list of products
+type Catalog struct {
+	Products []Product `json:"products"`
+}
+
+// ReadCatalog reads a catalog in JSON
+func ReadCatalog(r io.Reader) (*Catalog, error) {
+	c := &Catalog{}
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return nil, err
+	}
+
+	for _, p := range c.Products {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", p.ProductID, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Product returns a product in the catalog
+func (c *Catalog) Product(productID string) (*Product, bool) {
+	for i := range c.Products {
+		if c.Products[i].ProductID == productID {
+			return &c.Products[i], true
+		}
+	}
+	return nil, false
+}
+
+// ValidateReceipt returns an error if an in-app purchase of a receipt
+// is not a product in the catalog, or does not match the type of the
+// product
+func (c *Catalog) ValidateReceipt(r *receipt.Receipt) error {
+	for _, inApp := range r.InApp {
+		p, ok := c.Product(inApp.ProductID)
+		if !ok {
+			return fmt.Errorf("%s: %v", inApp.ProductID, ErrProductNotFound)
+		}
+
+		hasExpiresDate := !time.Time(inApp.ExpiresDate.Date).IsZero()
+
+		switch p.productType() {
+		case ProductTypeAutoRenewable:
+			if !hasExpiresDate {
+				return fmt.Errorf("%s: auto-renewable subscription must have expires_date", inApp.ProductID)
+			}
+		default:
+			if hasExpiresDate {
+				return fmt.Errorf("%s: %s product must not have expires_date", inApp.ProductID, p.productType())
+			}
+		}
+
+		if p.productType() == ProductTypeConsumable && inApp.OriginalTransactionID != "" && inApp.OriginalTransactionID != inApp.TransactionID {
+			return fmt.Errorf("%s: consumable product must not be restored", inApp.ProductID)
+		}
+	}
+
+	return nil
+}
+
+// GeneratorProducts returns the products of the catalog to generate
+// random receipts with
+func (c *Catalog) GeneratorProducts() []kreceipt.GeneratorProduct {
+	products := []kreceipt.GeneratorProduct{}
+	for _, p := range c.Products {
+		gp := kreceipt.GeneratorProduct{
+			ProductID: p.ProductID,
+			Type:      p.productType(),
+		}
+		if p.SubscriptionPeriod != "" {
+			gp.AddPeriod = p.SubscriptionPeriod.AddTo
+		}
+		products = append(products, gp)
+	}
+	return products
+}
+
+// AddCatalog adds the products of a catalog to the store
+func (s *Store) AddCatalog(c *Catalog) error {
+	for _, p := range c.Products {
+		if err := s.AddProduct(p); err != nil {
+			return fmt.Errorf("%s: %v", p.ProductID, err)
+		}
+	}
+	return nil
+}
+
+// Catalog returns the products of the store as a catalog
+func (s *Store) Catalog() *Catalog {
+	return &Catalog{Products: s.Products()}
+}
@@ -0,0 +1,144 @@
+package appstore
+
+import (
+	"strings"
+	"testing"
+
+	kreceipt "github.com/aktsk/kalvados/receipt"
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+const testCatalog = `{
+  "products": [
+    {"product_id": "coins", "type": "consumable"},
+    {"product_id": "premium", "type": "non_consumable"},
+    {"product_id": "season", "type": "non_renewing", "subscription_period": "P3M"},
+    {"product_id": "monthly", "type": "auto_renewable", "subscription_period": "P1M"}
+  ]
+}`
+
+func TestReadCatalog(t *testing.T) {
+	c, err := ReadCatalog(strings.NewReader(testCatalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p, ok := c.Product("monthly"); !ok || p.productType() != ProductTypeAutoRenewable {
+		t.Fatalf("Wrong product: %+v", p)
+	}
+
+	invalid := `{"products": [{"product_id": "coins", "type": "consumable", "subscription_period": "P1M"}]}`
+	if _, err := ReadCatalog(strings.NewReader(invalid)); err == nil {
+		t.Fatal("Consumable with subscription period should be invalid")
+	}
+
+	r := &receipt.Receipt{InApp: []*receipt.InApp{{ProductID: "coins", TransactionID: "1", OriginalTransactionID: "1"}}}
+	if err := c.ValidateReceipt(r); err != nil {
+		t.Fatal(err)
+	}
+
+	r.InApp[0].ProductID = "monthly"
+	if err := c.ValidateReceipt(r); err == nil {
+		t.Fatal("Auto-renewable subscription without expires_date should be invalid")
+	}
+
+	r.InApp[0].ProductID = "unknown"
+	if err := c.ValidateReceipt(r); err == nil {
+		t.Fatal("Unknown product should be invalid")
+	}
+}
+
+func TestGeneratorProducts(t *testing.T) {
+	c, err := ReadCatalog(strings.NewReader(testCatalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := kreceipt.NewGenerator(1, kreceipt.Constraints{Products: c.GeneratorProducts()})
+	for i := 0; i < 20; i++ {
+		r, err := g.Receipt().Nolmandy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ValidateReceipt(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPurchaseByProductType(t *testing.T) {
+	store := newTestStore(t)
+
+	c, err := ReadCatalog(strings.NewReader(testCatalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCatalog(c); err != nil {
+		t.Fatal(err)
+	}
+
+	coins1, err := store.Purchase(testBundleID, "coins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins2, err := store.Purchase(testBundleID, "coins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coins1.TransactionID == coins2.TransactionID {
+		t.Fatal("Consumable should be purchased again")
+	}
+
+	premium1, err := store.Purchase(testBundleID, "premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	premium2, err := store.Purchase(testBundleID, "premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if premium1.TransactionID != premium2.TransactionID {
+		t.Fatal("Non-consumable should be restored")
+	}
+
+	season, err := store.Purchase(testBundleID, "season")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !season.ExpiresDate.IsZero() {
+		t.Fatalf("Non-renewing subscription should not have expires_date: %v", season.ExpiresDate)
+	}
+
+	before, err := store.Receipt(testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Finish(coins1.TransactionID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Finish(premium1.TransactionID); err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt, err := store.Receipt(testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, inApp := range rcpt.InApp {
+		ids = append(ids, inApp.TransactionID)
+	}
+	if strings.Join(ids, ",") != strings.Join([]string{coins2.TransactionID, premium1.TransactionID, season.TransactionID}, ",") {
+		t.Fatalf("Finished consumable should be removed: %v", ids)
+	}
+
+	res, err := store.Verify(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Receipt.InApp) != 3 || len(res.LatestReceiptInfo) != 3 {
+		t.Fatalf("Wrong number of transactions: %d, %d", len(res.Receipt.InApp), len(res.LatestReceiptInfo))
+	}
+}
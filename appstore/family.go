@@ -0,0 +1,236 @@
+package appstore
+
+import (
+	"errors"
+	"sort"
+
+	kreceipt "github.com/aktsk/kalvados/receipt"
+)
+
+// ErrFamilyNotFound is returned when a family is not known to the store
+var ErrFamilyNotFound = errors.New("family not found")
+
+// ErrNotFamilyMember is returned when an account is not a member of a
+// family
+var ErrNotFamilyMember = errors.New("not a member of the family")
+
+// ErrInvalidFamily is returned when a family does not have an ID or an
+// organizer
+var ErrInvalidFamily = errors.New("family must have an ID and an organizer")
+
+// Ownership types of transactions
+// https://developer.apple.com/documentation/appstorereceipts/in_app_ownership_type
+const (
+	OwnershipTypePurchased    = "PURCHASED"
+	OwnershipTypeFamilyShared = "FAMILY_SHARED"
+)
+
+// Family is a Family Sharing group. Purchases of family shareable
+// products by the organizer are shared with the members.
+type Family struct {
+	ID          string   `json:"family_id"`
+	OrganizerID string   `json:"organizer_id"`
+	MemberIDs   []string `json:"member_ids"`
+}
+
+// WithAccount purchases a product by an account. Purchases without it
+// are made by the default account, whose ID is "".
+func WithAccount(accountID string) PurchaseOption {
+	return func(o *purchaseOptions) {
+		o.accountID = accountID
+	}
+}
+
+// AddFamily adds or replaces a family of the store. Purchases the
+// organizer has made are shared with the members. Replacing a family
+// revokes Family Sharing of the members who are no longer in it, as
+// RevokeFamilySharing does.
+func (s *Store) AddFamily(f Family) error {
+	if f.ID == "" || f.OrganizerID == "" {
+		return ErrInvalidFamily
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	f.MemberIDs = append([]string{}, f.MemberIDs...)
+
+	if old, ok := s.families[f.ID]; ok {
+		members := map[string]bool{}
+		if old.OrganizerID == f.OrganizerID {
+			for _, m := range f.MemberIDs {
+				members[m] = true
+			}
+		}
+		for _, m := range old.MemberIDs {
+			if !members[m] {
+				s.revokeSharedTransactions(old, m)
+			}
+		}
+	}
+
+	s.families[f.ID] = &f
+
+	transactions := []*Transaction{}
+	for _, t := range s.transactions {
+		if t.AccountID == f.OrganizerID && t.sharedFrom == "" {
+			transactions = append(transactions, t)
+		}
+	}
+
+	// The oldest transaction of a subscription becomes the original
+	// transaction of the shared one
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].PurchaseDate.Equal(transactions[j].PurchaseDate) {
+			return kreceipt.LessTransactionID(transactions[i].TransactionID, transactions[j].TransactionID)
+		}
+		return transactions[i].PurchaseDate.Before(transactions[j].PurchaseDate)
+	})
+
+	for _, t := range transactions {
+		s.share(t)
+	}
+
+	return nil
+}
+
+// Families returns the families of the store
+func (s *Store) Families() []Family {
+	s.lock()
+	defer s.unlock()
+
+	families := []Family{}
+	for _, f := range s.families {
+		copied := *f
+		copied.MemberIDs = append([]string{}, f.MemberIDs...)
+		families = append(families, copied)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].ID < families[j].ID
+	})
+
+	return families
+}
+
+// RevokeFamilySharing removes a member from a family. The transactions
+// shared with the member are removed, and a REVOKE notification is sent
+// for each of them.
+func (s *Store) RevokeFamilySharing(familyID, memberID string) ([]*Notification, error) {
+	s.lock()
+	defer s.unlock()
+
+	f, ok := s.families[familyID]
+	if !ok {
+		return nil, ErrFamilyNotFound
+	}
+
+	members := []string{}
+	for _, m := range f.MemberIDs {
+		if m != memberID {
+			members = append(members, m)
+		}
+	}
+	if len(members) == len(f.MemberIDs) {
+		return nil, ErrNotFamilyMember
+	}
+	f.MemberIDs = members
+
+	return s.revokeSharedTransactions(f, memberID), nil
+}
+
+// revokeSharedTransactions removes the transactions the organizer of a
+// family has shared with a member, and sends a REVOKE notification for
+// each of them. It must be called with s.mu held.
+func (s *Store) revokeSharedTransactions(f *Family, memberID string) []*Notification {
+	revoked := map[string]*Transaction{}
+	for id, t := range s.transactions {
+		if t.AccountID != memberID || t.sharedFrom == "" {
+			continue
+		}
+		if source, ok := s.transactions[t.sharedFrom]; ok && source.AccountID != f.OrganizerID {
+			continue
+		}
+		revoked[t.OriginalTransactionID] = t
+		delete(s.transactions, id)
+	}
+
+	ids := []string{}
+	for id := range revoked {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	notifications := []*Notification{}
+	for _, id := range ids {
+		t := revoked[id]
+		notifications = append(notifications, s.notify(NotificationTypeRevoke, t.AccountID, t.BundleID, id))
+	}
+
+	return notifications
+}
+
+// share shares a transaction with the family members of its account if
+// the product is family shareable. It must be called with s.mu held.
+func (s *Store) share(t *Transaction) {
+	p, ok := s.products[t.ProductID]
+	if !ok || !p.FamilyShareable {
+		return
+	}
+
+	for _, f := range s.families {
+		if f.OrganizerID != t.AccountID {
+			continue
+		}
+		for _, m := range f.MemberIDs {
+			s.shareWith(t, m)
+		}
+	}
+}
+
+func (s *Store) shareWith(t *Transaction, memberID string) {
+	originalTransactionID := ""
+	for _, shared := range s.transactions {
+		if shared.AccountID != memberID || shared.sharedFrom == "" {
+			continue
+		}
+		if shared.sharedFrom == t.TransactionID {
+			return
+		}
+		if source, ok := s.transactions[shared.sharedFrom]; ok && source.OriginalTransactionID == t.OriginalTransactionID {
+			originalTransactionID = shared.OriginalTransactionID
+		}
+	}
+
+	shared := *t
+	shared.AccountID = memberID
+	shared.TransactionID = s.newTransactionID()
+	shared.OriginalTransactionID = originalTransactionID
+	shared.InAppOwnershipType = OwnershipTypeFamilyShared
+	shared.sharedFrom = t.TransactionID
+
+	if shared.OriginalTransactionID == "" {
+		shared.OriginalTransactionID = shared.TransactionID
+	}
+
+	s.transactions[shared.TransactionID] = &shared
+}
+
+// syncSharedTransactions updates shared transactions with changes of
+// the organizer's ones, like upgrades and refunds. It must be called
+// with s.mu held.
+func (s *Store) syncSharedTransactions() {
+	for _, shared := range s.transactions {
+		if shared.sharedFrom == "" {
+			continue
+		}
+		source, ok := s.transactions[shared.sharedFrom]
+		if !ok {
+			continue
+		}
+		shared.ExpiresDate = source.ExpiresDate
+		shared.CancellationDate = source.CancellationDate
+		shared.CancellationReason = source.CancellationReason
+		shared.IsUpgraded = source.IsUpgraded
+	}
+}
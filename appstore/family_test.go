@@ -0,0 +1,170 @@
+package appstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFamilySharing(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.AddProduct(Product{ProductID: testProductID, SubscriptionPeriod: "P1M", FamilyShareable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purchased, err := store.Purchase(testBundleID, testProductID, WithAccount("organizer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.AddFamily(Family{ID: "family", OrganizerID: "organizer", MemberIDs: []string{"member"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Clock.Advance(31 * 24 * time.Hour)
+
+	rcpt, err := store.ReceiptFor("member", testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rcpt.InApp) != 2 {
+		t.Fatalf("Wrong number of shared transactions: %d", len(rcpt.InApp))
+	}
+
+	first, _ := store.Transaction(rcpt.InApp[0].TransactionID)
+	second, _ := store.Transaction(rcpt.InApp[1].TransactionID)
+	if first.InAppOwnershipType != OwnershipTypeFamilyShared || second.InAppOwnershipType != OwnershipTypeFamilyShared {
+		t.Fatalf("Wrong in_app_ownership_type: %s, %s", first.InAppOwnershipType, second.InAppOwnershipType)
+	}
+
+	if first.TransactionID == purchased.TransactionID {
+		t.Fatal("Shared transaction should have its own transaction_id")
+	}
+
+	if second.OriginalTransactionID != first.TransactionID {
+		t.Fatalf("Wrong original_transaction_id of shared renewal: %s", second.OriginalTransactionID)
+	}
+
+	if _, err := store.RevokeFamilySharing("family", "stranger"); err != ErrNotFamilyMember {
+		t.Fatalf("Non-member should not be revoked: %v", err)
+	}
+
+	notifications, err := store.RevokeFamilySharing("family", "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifications) != 1 || notifications[0].NotificationType != NotificationTypeRevoke {
+		t.Fatalf("Wrong notifications: %+v", notifications)
+	}
+
+	if len(notifications[0].UnifiedReceipt.LatestReceiptInfo) != 0 {
+		t.Fatal("Revoked transactions should be removed")
+	}
+
+	rcpt, err = store.ReceiptFor("member", testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rcpt.InApp) != 0 {
+		t.Fatalf("Revoked transactions should be removed: %d", len(rcpt.InApp))
+	}
+
+	rcpt, err = store.ReceiptFor("organizer", testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rcpt.InApp) != 2 {
+		t.Fatalf("Organizer's transactions should be kept: %d", len(rcpt.InApp))
+	}
+}
+
+func TestFamilyShareableConsumable(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.AddProduct(Product{ProductID: "coins", Type: ProductTypeConsumable, FamilyShareable: true})
+	if err != ErrInvalidProductType {
+		t.Fatalf("Consumable should not be family shareable: %v", err)
+	}
+}
+
+func TestFamilySharingOfRenewedSubscription(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.AddProduct(Product{ProductID: testProductID, SubscriptionPeriod: "P1M", FamilyShareable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The renewal has a transaction ID with more digits
+	store.lastID = 99998
+
+	if _, err := store.Purchase(testBundleID, testProductID, WithAccount("organizer")); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Clock.Advance(31 * 24 * time.Hour)
+
+	if err := store.AddFamily(Family{ID: "family", OrganizerID: "organizer", MemberIDs: []string{"member"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt, err := store.ReceiptFor("member", testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rcpt.InApp) != 2 {
+		t.Fatalf("Wrong number of shared transactions: %d", len(rcpt.InApp))
+	}
+
+	first, _ := store.Transaction(rcpt.InApp[0].TransactionID)
+	second, _ := store.Transaction(rcpt.InApp[1].TransactionID)
+	if !first.PurchaseDate.Before(second.PurchaseDate) || second.OriginalTransactionID != first.TransactionID {
+		t.Fatalf("Shared purchase should be the original transaction: %+v, %+v", first, second)
+	}
+}
+
+func TestReplaceFamily(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.AddProduct(Product{ProductID: testProductID, SubscriptionPeriod: "P1M", FamilyShareable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Purchase(testBundleID, testProductID, WithAccount("organizer")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.AddFamily(Family{ID: "family", OrganizerID: "organizer", MemberIDs: []string{"member", "other"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.AddFamily(Family{ID: "family", OrganizerID: "organizer", MemberIDs: []string{"other"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	notifications := store.Notifications()
+	if len(notifications) != 2 || notifications[1].NotificationType != NotificationTypeRevoke {
+		t.Fatalf("Wrong notifications: %+v", notifications)
+	}
+
+	for _, tc := range []struct {
+		accountID string
+		n         int
+	}{
+		{"member", 0},
+		{"other", 1},
+	} {
+		rcpt, err := store.ReceiptFor(tc.accountID, testBundleID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rcpt.InApp) != tc.n {
+			t.Fatalf("Wrong number of shared transactions of %s: %d", tc.accountID, len(rcpt.InApp))
+		}
+	}
+}
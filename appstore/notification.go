@@ -0,0 +1,81 @@
+package appstore
+
+import (
+	"strconv"
+
+	kreceipt "github.com/aktsk/kalvados/receipt"
+)
+
+// Notification types
+// https://developer.apple.com/documentation/appstoreservernotifications/notification_type
+const (
+	NotificationTypeInitialBuy             = "INITIAL_BUY"
+	NotificationTypeInteractiveRenewal     = "INTERACTIVE_RENEWAL"
+	NotificationTypeDidRenew               = "DID_RENEW"
+	NotificationTypeDidChangeRenewalPref   = "DID_CHANGE_RENEWAL_PREF"
+	NotificationTypeDidChangeRenewalStatus = "DID_CHANGE_RENEWAL_STATUS"
+	NotificationTypeDidFailToRenew         = "DID_FAIL_TO_RENEW"
+	NotificationTypeDidRecover             = "DID_RECOVER"
+	NotificationTypeGracePeriodExpired     = "GRACE_PERIOD_EXPIRED"
+	NotificationTypePriceIncreaseConsent   = "PRICE_INCREASE_CONSENT"
+	NotificationTypeRefund                 = "REFUND"
+	NotificationTypeRevoke                 = "REVOKE"
+)
+
+// Notification is an App Store server notification
+// https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv1
+type Notification struct {
+	NotificationType      string          `json:"notification_type"`
+	Environment           string          `json:"environment"`
+	BundleID              string          `json:"bid"`
+	OriginalTransactionID string          `json:"original_transaction_id,omitempty"`
+	AutoRenewProductID    string          `json:"auto_renew_product_id,omitempty"`
+	AutoRenewStatus       string          `json:"auto_renew_status,omitempty"`
+	ExpirationIntent      string          `json:"expiration_intent,omitempty"`
+	NotificationDate      string          `json:"notification_date"`
+	NotificationDateMS    string          `json:"notification_date_ms"`
+	NotificationDatePST   string          `json:"notification_date_pst"`
+	UnifiedReceipt        *UnifiedReceipt `json:"unified_receipt"`
+}
+
+// UnifiedReceipt is the receipt information in a notification
+type UnifiedReceipt struct {
+	Status             int                            `json:"status"`
+	Environment        string                         `json:"environment"`
+	LatestReceiptInfo  []*kreceipt.ResponseInApp      `json:"latest_receipt_info"`
+	PendingRenewalInfo []*kreceipt.PendingRenewalInfo `json:"pending_renewal_info,omitempty"`
+}
+
+// notify queues a notification about a transaction, dated by the clock
+// of the store. It must be called with the store locked, and the
+// notification is sent when the store is unlocked.
+func (s *Store) notify(notificationType, accountID, bundleID, originalTransactionID string) *Notification {
+	n := &Notification{
+		NotificationType:      notificationType,
+		Environment:           "Sandbox",
+		BundleID:              bundleID,
+		OriginalTransactionID: originalTransactionID,
+		UnifiedReceipt: &UnifiedReceipt{
+			Status:            0,
+			Environment:       "Sandbox",
+			LatestReceiptInfo: s.latestReceiptInfo(accountID, bundleID),
+		},
+	}
+
+	n.NotificationDate, n.NotificationDateMS, n.NotificationDatePST = kreceipt.FormatDate(s.Clock.Now())
+
+	if renewal := s.pendingRenewalInfo(accountID, bundleID); len(renewal) > 0 {
+		n.UnifiedReceipt.PendingRenewalInfo = renewal
+	}
+
+	if sub, ok := s.subscriptions[originalTransactionID]; ok {
+		n.AutoRenewProductID = sub.AutoRenewProductID
+		n.AutoRenewStatus = strconv.FormatBool(sub.AutoRenewStatus)
+		n.ExpirationIntent = sub.ExpirationIntent
+	}
+
+	s.notifications = append(s.notifications, n)
+	s.pending = append(s.pending, n)
+
+	return n
+}
@@ -0,0 +1,218 @@
+package appstore
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrOfferNotFound is returned when an offer is not found in a product
+var ErrOfferNotFound = errors.New("offer not found")
+
+// ErrNotEligible is returned when a customer is not eligible for an offer
+var ErrNotEligible = errors.New("not eligible for the offer")
+
+// ErrInvalidOffer is returned when an offer of a product is not valid
+var ErrInvalidOffer = errors.New("offer must have a payment mode and a period")
+
+// Payment modes of offers
+const (
+	PaymentModeFreeTrial  = "free_trial"
+	PaymentModePayAsYouGo = "pay_as_you_go"
+	PaymentModePayUpFront = "pay_up_front"
+)
+
+// Offer is an introductory offer, a promotional offer or an offer code
+// of an auto-renewable subscription
+type Offer struct {
+	// ID is the identifier of a promotional offer, or the reference
+	// name of an offer code
+	ID string `json:"id,omitempty"`
+
+	// PaymentMode is one of "free_trial", "pay_as_you_go" and
+	// "pay_up_front"
+	PaymentMode string `json:"payment_mode"`
+
+	// Period is the period of a payment for pay as you go, and the
+	// unit of the duration for free trial and pay up front
+	Period Period `json:"period"`
+
+	// NumberOfPeriods is the number of periods. It defaults to 1.
+	NumberOfPeriods int `json:"number_of_periods,omitempty"`
+}
+
+// Validate returns an error if the offer is not valid
+func (o *Offer) Validate() error {
+	switch o.PaymentMode {
+	case PaymentModeFreeTrial, PaymentModePayAsYouGo, PaymentModePayUpFront:
+	default:
+		return ErrInvalidOffer
+	}
+
+	if o.NumberOfPeriods < 0 {
+		return ErrInvalidOffer
+	}
+
+	return o.Period.Validate()
+}
+
+// transactions returns the number of transactions the offer lasts
+func (o *Offer) transactions() int {
+	if o.PaymentMode == PaymentModePayAsYouGo {
+		return o.numberOfPeriods()
+	}
+	return 1
+}
+
+// addTo returns the expiration date of a transaction with the offer
+// purchased at t
+func (o *Offer) addTo(t time.Time) time.Time {
+	if o.PaymentMode == PaymentModePayAsYouGo {
+		return o.Period.AddTo(t)
+	}
+
+	for i := 0; i < o.numberOfPeriods(); i++ {
+		t = o.Period.AddTo(t)
+	}
+	return t
+}
+
+func (o *Offer) numberOfPeriods() int {
+	if o.NumberOfPeriods == 0 {
+		return 1
+	}
+	return o.NumberOfPeriods
+}
+
+// Kinds of offers
+const (
+	offerIntroductory = iota
+	offerPromotional
+	offerCode
+)
+
+// subscriptionOffer is an offer applied to a subscription
+type subscriptionOffer struct {
+	kind      int
+	offer     Offer
+	remaining int
+}
+
+func newSubscriptionOffer(kind int, o Offer) *subscriptionOffer {
+	return &subscriptionOffer{kind: kind, offer: o, remaining: o.transactions()}
+}
+
+// PurchaseOption configures a purchase
+type PurchaseOption func(*purchaseOptions)
+
+type purchaseOptions struct {
+	accountID          string
+	promotionalOfferID string
+	offerCodeRefName   string
+}
+
+// WithPromotionalOffer purchases a subscription with a promotional
+// offer. Only customers who have subscribed to the subscription group
+// are eligible.
+func WithPromotionalOffer(id string) PurchaseOption {
+	return func(o *purchaseOptions) {
+		o.promotionalOfferID = id
+	}
+}
+
+// WithOfferCode purchases a subscription with an offer code
+func WithOfferCode(refName string) PurchaseOption {
+	return func(o *purchaseOptions) {
+		o.offerCodeRefName = refName
+	}
+}
+
+// redemption is an offer redeemed by an account in a subscription
+// group. offerID is empty for introductory offers.
+type redemption struct {
+	kind      int
+	accountID string
+	bundleID  string
+	groupID   string
+	offerID   string
+}
+
+// offerFor returns the offer a purchase of a product applies, and
+// records its redemption. The introductory offer is applied once for new
+// subscriptions in a group, and an offer code can be redeemed once by
+// an account. Transactions recorded from receipts also count as
+// redemptions of the introductory offer.
+func (s *Store) offerFor(bundleID string, p *Product, sub *Subscription, o *purchaseOptions) (*subscriptionOffer, error) {
+	if o.promotionalOfferID != "" {
+		if sub == nil {
+			return nil, ErrNotEligible
+		}
+		for _, offer := range p.PromotionalOffers {
+			if offer.ID == o.promotionalOfferID {
+				return newSubscriptionOffer(offerPromotional, offer), nil
+			}
+		}
+		return nil, ErrOfferNotFound
+	}
+
+	if o.offerCodeRefName != "" {
+		for _, offer := range p.OfferCodes {
+			if offer.ID != o.offerCodeRefName {
+				continue
+			}
+			r := redemption{offerCode, o.accountID, bundleID, p.groupID(), offer.ID}
+			if s.redeemed[r] {
+				return nil, ErrNotEligible
+			}
+			s.redeemed[r] = true
+			return newSubscriptionOffer(offerCode, offer), nil
+		}
+		return nil, ErrOfferNotFound
+	}
+
+	if sub != nil || p.IntroductoryOffer == nil {
+		return nil, nil
+	}
+
+	r := redemption{offerIntroductory, o.accountID, bundleID, p.groupID(), ""}
+	if s.redeemed[r] || s.hasIntroductoryTransaction(o.accountID, bundleID, p.groupID()) {
+		return nil, nil
+	}
+	s.redeemed[r] = true
+	return newSubscriptionOffer(offerIntroductory, *p.IntroductoryOffer), nil
+}
+
+// hasIntroductoryTransaction returns whether an account has a
+// transaction in a trial or an introductory period of a subscription
+// group, like transactions recorded from receipts
+func (s *Store) hasIntroductoryTransaction(accountID, bundleID, groupID string) bool {
+	for _, t := range s.transactionsOf(accountID, bundleID) {
+		p, ok := s.products[t.ProductID]
+		if ok && p.groupID() == groupID && (t.IsTrialPeriod || t.IsInIntroOfferPeriod) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the offer to a transaction and returns its expiration
+// date
+func (so *subscriptionOffer) apply(t *Transaction) time.Time {
+	switch so.kind {
+	case offerIntroductory:
+		if so.offer.PaymentMode == PaymentModeFreeTrial {
+			t.IsTrialPeriod = true
+		} else {
+			t.IsInIntroOfferPeriod = true
+		}
+	case offerPromotional:
+		t.PromotionalOfferID = so.offer.ID
+		t.IsTrialPeriod = so.offer.PaymentMode == PaymentModeFreeTrial
+	case offerCode:
+		t.OfferCodeRefName = so.offer.ID
+		t.IsTrialPeriod = so.offer.PaymentMode == PaymentModeFreeTrial
+	}
+
+	so.remaining--
+
+	return so.offer.addTo(t.PurchaseDate)
+}
@@ -0,0 +1,104 @@
+package appstore
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrInvalidPrice is returned when a price is not a non-negative
+// decimal
+var ErrInvalidPrice = errors.New("price must be a non-negative decimal like 4.99")
+
+// ErrNoPriceIncrease is returned when a subscription has no price
+// increase waiting for consent
+var ErrNoPriceIncrease = errors.New("no price increase waiting for consent")
+
+// Price consent statuses
+// https://developer.apple.com/documentation/appstorereceipts/price_consent_status
+const (
+	PriceConsentStatusPending  = "0"
+	PriceConsentStatusAccepted = "1"
+)
+
+func parsePrice(price string) (float64, error) {
+	f, err := strconv.ParseFloat(price, 64)
+	if err != nil || f < 0 {
+		return 0, ErrInvalidPrice
+	}
+	return f, nil
+}
+
+// ChangePrice changes the price of a product. A price increase of an
+// auto-renewable subscription requires consent of the subscribers who
+// renew to it, and a PRICE_INCREASE_CONSENT notification is sent for
+// each of them. Subscriptions which have not been consented by the
+// renewal expire.
+func (s *Store) ChangePrice(productID, price string) ([]*Notification, error) {
+	newPrice, err := parsePrice(price)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	p, ok := s.products[productID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+
+	increased := false
+	if p.Price != "" {
+		oldPrice, err := parsePrice(p.Price)
+		if err != nil {
+			return nil, err
+		}
+		increased = newPrice > oldPrice
+	}
+
+	p.Price = price
+
+	notifications := []*Notification{}
+	if !increased || p.productType() != ProductTypeAutoRenewable {
+		return notifications, nil
+	}
+
+	now := s.Clock.Now()
+	for _, sub := range s.sortedSubscriptions() {
+		if sub.AutoRenewProductID != productID || !sub.AutoRenewStatus || !sub.isActive(now) {
+			continue
+		}
+		sub.PriceConsentStatus = PriceConsentStatusPending
+		notifications = append(notifications, s.notify(NotificationTypePriceIncreaseConsent, sub.AccountID, sub.BundleID, sub.OriginalTransactionID))
+	}
+
+	return notifications, nil
+}
+
+// SetPriceConsent accepts or declines a price increase of a
+// subscription. Declining turns off auto-renew, and the subscription
+// expires with the expiration intent of price increase.
+func (s *Store) SetPriceConsent(originalTransactionID string, consent bool) (*Notification, error) {
+	s.lock()
+	defer s.unlock()
+
+	sub, ok := s.subscriptions[originalTransactionID]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	if sub.PriceConsentStatus == "" {
+		return nil, ErrNoPriceIncrease
+	}
+
+	if consent {
+		sub.PriceConsentStatus = PriceConsentStatusAccepted
+		return nil, nil
+	}
+
+	sub.PriceConsentStatus = PriceConsentStatusPending
+	sub.AutoRenewStatus = false
+	sub.ExpirationIntent = ExpirationIntentPriceIncrease
+
+	return s.notify(NotificationTypeDidChangeRenewalStatus, sub.AccountID, sub.BundleID, sub.OriginalTransactionID), nil
+}
@@ -0,0 +1,77 @@
+package appstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceIncrease(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.AddProduct(Product{ProductID: testProductID, SubscriptionPeriod: "P1M", Price: "4.99"}); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted, err := store.Purchase(testBundleID, testProductID, WithAccount("accepted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	declined, err := store.Purchase(testBundleID, testProductID, WithAccount("declined"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ignored, err := store.Purchase(testBundleID, testProductID, WithAccount("ignored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.ChangePrice(testProductID, "-1"); err != ErrInvalidPrice {
+		t.Fatalf("Negative price should be invalid: %v", err)
+	}
+
+	notifications, err := store.ChangePrice(testProductID, "5.99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 3 || notifications[0].NotificationType != NotificationTypePriceIncreaseConsent {
+		t.Fatalf("Wrong notifications: %+v", notifications)
+	}
+
+	sub, _ := store.Subscription(accepted.OriginalTransactionID)
+	if info := sub.pendingRenewalInfo(); info.PriceConsentStatus != PriceConsentStatusPending {
+		t.Fatalf("Wrong price_consent_status: %s", info.PriceConsentStatus)
+	}
+
+	if _, err := store.SetPriceConsent(accepted.OriginalTransactionID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetPriceConsent(declined.OriginalTransactionID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, _ = store.Subscription(accepted.OriginalTransactionID)
+	if sub.PriceConsentStatus != PriceConsentStatusAccepted {
+		t.Fatalf("Wrong price_consent_status: %s", sub.PriceConsentStatus)
+	}
+
+	store.Clock.Advance(31 * 24 * time.Hour)
+
+	sub, _ = store.Subscription(accepted.OriginalTransactionID)
+	if !sub.ExpiresDate.Equal(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)) || sub.PriceConsentStatus != "" {
+		t.Fatalf("Consented subscription should renew: %+v", sub)
+	}
+
+	for _, id := range []string{declined.OriginalTransactionID, ignored.OriginalTransactionID} {
+		sub, _ = store.Subscription(id)
+		if sub.AutoRenewStatus || sub.ExpirationIntent != ExpirationIntentPriceIncrease {
+			t.Fatalf("Subscription should expire with price increase: %+v", sub)
+		}
+		if !sub.ExpiresDate.Equal(time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("Subscription should not renew: %v", sub.ExpiresDate)
+		}
+	}
+
+	if _, err := store.SetPriceConsent(accepted.OriginalTransactionID, true); err != ErrNoPriceIncrease {
+		t.Fatalf("Consent without price increase should fail: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package appstore
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrProductNotFound is returned when a product is not known to the store
+var ErrProductNotFound = errors.New("product not found")
+
+// ErrInvalidPeriod is returned when a subscription period is not in
+// ISO 8601 duration format like "P1M"
+var ErrInvalidPeriod = errors.New("subscription period must be like P1W, P1M or P1Y")
+
+// ErrInvalidGroupLevel is returned when a subscription in a subscription
+// group does not have a level
+var ErrInvalidGroupLevel = errors.New("subscription group level must be 1 or more")
+
+// Product is an in-app purchase product
+type Product struct {
+	ProductID string `json:"product_id"`
+
+	// Type is one of "consumable", "non_consumable", "auto_renewable"
+	// and "non_renewing". It defaults to "auto_renewable" for products
+	// with a subscription period, and "non_consumable" otherwise.
+	Type string `json:"type,omitempty"`
+
+	// Price is the price of the product in decimal like "4.99"
+	Price string `json:"price,omitempty"`
+
+	// SubscriptionPeriod is the period of a subscription in ISO 8601
+	// duration format like "P1M". Non-renewing subscriptions are not
+	// renewed after the period.
+	SubscriptionPeriod Period `json:"subscription_period,omitempty"`
+
+	// SubscriptionGroupID is the subscription group of an
+	// auto-renewable subscription. A subscription without a group is
+	// in its own group.
+	SubscriptionGroupID string `json:"subscription_group_id,omitempty"`
+
+	// SubscriptionGroupLevel is the level of an auto-renewable
+	// subscription in its group. Level 1 is the highest.
+	SubscriptionGroupLevel int `json:"subscription_group_level,omitempty"`
+
+	// IntroductoryOffer is applied to customers who subscribe to the
+	// subscription group for the first time
+	IntroductoryOffer *Offer `json:"introductory_offer,omitempty"`
+
+	// PromotionalOffers are offers for customers who have subscribed
+	// to the subscription group
+	PromotionalOffers []Offer `json:"promotional_offers,omitempty"`
+
+	// OfferCodes are offers redeemed with offer codes. ID of an offer
+	// code is its reference name.
+	OfferCodes []Offer `json:"offer_codes,omitempty"`
+
+	// FamilyShareable makes purchases of the product shared with the
+	// members of the purchaser's family. Consumables cannot be shared.
+	FamilyShareable bool `json:"family_shareable,omitempty"`
+}
+
+// groupID returns the subscription group of the product
+func (p *Product) groupID() string {
+	if p.SubscriptionGroupID == "" {
+		return p.ProductID
+	}
+	return p.SubscriptionGroupID
+}
+
+// Period is a period in ISO 8601 duration format like "P1M". Only one
+// of days, weeks, months or years is supported.
+type Period string
+
+var periodRegexp = regexp.MustCompile(`^P([1-9][0-9]*)([DWMY])$`)
+
+// Validate returns an error if the period is not valid
+func (p Period) Validate() error {
+	if !periodRegexp.MatchString(string(p)) {
+		return ErrInvalidPeriod
+	}
+	return nil
+}
+
+// AddTo returns t plus the period
+func (p Period) AddTo(t time.Time) time.Time {
+	m := periodRegexp.FindStringSubmatch(string(p))
+	if m == nil {
+		return t
+	}
+
+	n, _ := strconv.Atoi(m[1])
+
+	switch m[2] {
+	case "D":
+		return t.AddDate(0, 0, n)
+	case "W":
+		return t.AddDate(0, 0, 7*n)
+	case "M":
+		return t.AddDate(0, n, 0)
+	default:
+		return t.AddDate(n, 0, 0)
+	}
+}
+
+// gracePeriod returns the length of billing grace period for a
+// subscription period
+func (p Period) gracePeriod() time.Duration {
+	if p.AddTo(time.Time{}).Sub(time.Time{}) <= 7*24*time.Hour {
+		return 6 * 24 * time.Hour
+	}
+	return 16 * 24 * time.Hour
+}
+
+// Validate returns an error if the product is not valid
+func (p *Product) Validate() error {
+	if err := p.validateType(); err != nil {
+		return err
+	}
+
+	if p.SubscriptionPeriod != "" {
+		if err := p.SubscriptionPeriod.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if p.SubscriptionGroupID != "" && p.SubscriptionGroupLevel < 1 {
+		return ErrInvalidGroupLevel
+	}
+
+	if p.Price != "" {
+		if _, err := parsePrice(p.Price); err != nil {
+			return err
+		}
+	}
+
+	offers := append(append([]Offer{}, p.PromotionalOffers...), p.OfferCodes...)
+	if p.IntroductoryOffer != nil {
+		offers = append(offers, *p.IntroductoryOffer)
+	}
+	for _, o := range offers {
+		if p.productType() != ProductTypeAutoRenewable {
+			return ErrInvalidOffer
+		}
+		if err := o.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddProduct adds or replaces a product of the store
+func (s *Store) AddProduct(p Product) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	s.products[p.ProductID] = &p
+
+	return nil
+}
+
+// Products returns the products of the store
+func (s *Store) Products() []Product {
+	s.lock()
+	defer s.unlock()
+
+	products := []Product{}
+	for _, p := range s.products {
+		products = append(products, *p)
+	}
+
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ProductID < products[j].ProductID
+	})
+
+	return products
+}
@@ -0,0 +1,166 @@
+package appstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// storeKitConfiguration is a StoreKit configuration file of Xcode
+type storeKitConfiguration struct {
+	Products                 []storeKitProduct `json:"products"`
+	NonRenewingSubscriptions []storeKitProduct `json:"nonRenewingSubscriptions"`
+	SubscriptionGroups       []struct {
+		ID            string            `json:"id"`
+		Subscriptions []storeKitProduct `json:"subscriptions"`
+	} `json:"subscriptionGroups"`
+}
+
+type storeKitProduct struct {
+	ProductID                   string          `json:"productID"`
+	Type                        string          `json:"type"`
+	DisplayPrice                string          `json:"displayPrice"`
+	FamilyShareable             bool            `json:"familyShareable"`
+	GroupNumber                 int             `json:"groupNumber"`
+	SubscriptionGroupID         string          `json:"subscriptionGroupID"`
+	RecurringSubscriptionPeriod string          `json:"recurringSubscriptionPeriod"`
+	IntroductoryOffer           *storeKitOffer  `json:"introductoryOffer"`
+	AdHocOffers                 []storeKitOffer `json:"adHocOffers"`
+	CodeOffers                  []storeKitOffer `json:"codeOffers"`
+}
+
+type storeKitOffer struct {
+	OfferID            string `json:"offerID"`
+	ReferenceName      string `json:"referenceName"`
+	PaymentMode        string `json:"paymentMode"`
+	SubscriptionPeriod string `json:"subscriptionPeriod"`
+	NumberOfPeriods    int    `json:"numberOfPeriods"`
+}
+
+var storeKitProductTypes = map[string]string{
+	"Consumable":              ProductTypeConsumable,
+	"NonConsumable":           ProductTypeNonConsumable,
+	"RecurringSubscription":   ProductTypeAutoRenewable,
+	"NonRenewingSubscription": ProductTypeNonRenewing,
+}
+
+var storeKitPaymentModes = map[string]string{
+	"free":       PaymentModeFreeTrial,
+	"payAsYouGo": PaymentModePayAsYouGo,
+	"payUpFront": PaymentModePayUpFront,
+}
+
+// ReadStoreKitConfiguration reads a StoreKit configuration file of
+// Xcode (.storekit) as a catalog
+func ReadStoreKitConfiguration(r io.Reader) (*Catalog, error) {
+	var conf storeKitConfiguration
+	if err := json.NewDecoder(r).Decode(&conf); err != nil {
+		return nil, err
+	}
+
+	skProducts := append(append([]storeKitProduct{}, conf.Products...), conf.NonRenewingSubscriptions...)
+	for _, g := range conf.SubscriptionGroups {
+		for _, sub := range g.Subscriptions {
+			if sub.SubscriptionGroupID == "" {
+				sub.SubscriptionGroupID = g.ID
+			}
+			skProducts = append(skProducts, sub)
+		}
+	}
+
+	c := &Catalog{Products: []Product{}}
+	for _, sk := range skProducts {
+		p, err := sk.product()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", sk.ProductID, err)
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", sk.ProductID, err)
+		}
+		c.Products = append(c.Products, *p)
+	}
+
+	return c, nil
+}
+
+func (sk *storeKitProduct) product() (*Product, error) {
+	productType, ok := storeKitProductTypes[sk.Type]
+	if !ok {
+		return nil, ErrInvalidProductType
+	}
+
+	p := &Product{
+		ProductID:       sk.ProductID,
+		Type:            productType,
+		Price:           sk.DisplayPrice,
+		FamilyShareable: sk.FamilyShareable,
+	}
+
+	if productType != ProductTypeAutoRenewable {
+		return p, nil
+	}
+
+	p.SubscriptionPeriod = Period(sk.RecurringSubscriptionPeriod)
+	p.SubscriptionGroupID = sk.SubscriptionGroupID
+	p.SubscriptionGroupLevel = sk.GroupNumber
+
+	if sk.IntroductoryOffer != nil {
+		o, err := sk.IntroductoryOffer.offer("")
+		if err != nil {
+			return nil, err
+		}
+		p.IntroductoryOffer = o
+	}
+
+	for _, skOffer := range sk.AdHocOffers {
+		o, err := skOffer.offer(skOffer.OfferID)
+		if err != nil {
+			return nil, err
+		}
+		p.PromotionalOffers = append(p.PromotionalOffers, *o)
+	}
+
+	// Offer codes are identified by their reference names
+	for _, skOffer := range sk.CodeOffers {
+		o, err := skOffer.offer(skOffer.ReferenceName)
+		if err != nil {
+			return nil, err
+		}
+		p.OfferCodes = append(p.OfferCodes, *o)
+	}
+
+	return p, nil
+}
+
+func (sk *storeKitOffer) offer(id string) (*Offer, error) {
+	paymentMode, ok := storeKitPaymentModes[sk.PaymentMode]
+	if !ok {
+		return nil, ErrInvalidOffer
+	}
+
+	return &Offer{
+		ID:              id,
+		PaymentMode:     paymentMode,
+		Period:          Period(sk.SubscriptionPeriod),
+		NumberOfPeriods: sk.NumberOfPeriods,
+	}, nil
+}
+
+// OpenCatalog reads a catalog file. Files with .storekit extension are
+// read as StoreKit configuration files of Xcode.
+func OpenCatalog(name string) (*Catalog, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if filepath.Ext(name) == ".storekit" {
+		return ReadStoreKitConfiguration(f)
+	}
+
+	return ReadCatalog(f)
+}
@@ -0,0 +1,124 @@
+package appstore
+
+import (
+	"strings"
+	"testing"
+)
+
+const testStoreKitConfiguration = `{
+  "identifier" : "4A6B2E1C",
+  "nonRenewingSubscriptions" : [
+    {
+      "displayPrice" : "9.99",
+      "familyShareable" : false,
+      "internalID" : "6D0F8B1A",
+      "localizations" : [],
+      "productID" : "season",
+      "referenceName" : "Season Pass",
+      "type" : "NonRenewingSubscription"
+    }
+  ],
+  "products" : [
+    {
+      "displayPrice" : "0.99",
+      "familyShareable" : false,
+      "internalID" : "1C0E6A2B",
+      "localizations" : [],
+      "productID" : "coins",
+      "referenceName" : "Coins",
+      "type" : "Consumable"
+    }
+  ],
+  "settings" : {},
+  "subscriptionGroups" : [
+    {
+      "id" : "21443625",
+      "localizations" : [],
+      "name" : "Premium",
+      "subscriptions" : [
+        {
+          "adHocOffers" : [
+            {
+              "internalID" : "3F7C9D2E",
+              "offerID" : "winback",
+              "paymentMode" : "payAsYouGo",
+              "referenceName" : "Win Back",
+              "subscriptionPeriod" : "P1M",
+              "numberOfPeriods" : 3
+            }
+          ],
+          "codeOffers" : [
+            {
+              "internalID" : "8E2A4C6F",
+              "isEligibleNewCustomer" : true,
+              "isEligibleExistingCustomer" : true,
+              "isEligibleExpiredCustomer" : true,
+              "paymentMode" : "free",
+              "referenceName" : "spring",
+              "subscriptionPeriod" : "P1M"
+            }
+          ],
+          "displayPrice" : "4.99",
+          "familyShareable" : true,
+          "groupNumber" : 1,
+          "internalID" : "5B3D7F9A",
+          "introductoryOffer" : {
+            "internalID" : "9A1B3C5D",
+            "paymentMode" : "free",
+            "subscriptionPeriod" : "P1W"
+          },
+          "localizations" : [],
+          "productID" : "monthly",
+          "recurringSubscriptionPeriod" : "P1M",
+          "referenceName" : "Monthly",
+          "subscriptionGroupID" : "21443625",
+          "type" : "RecurringSubscription"
+        }
+      ]
+    }
+  ],
+  "version" : {
+    "major" : 1,
+    "minor" : 0
+  }
+}`
+
+func TestReadStoreKitConfiguration(t *testing.T) {
+	c, err := ReadStoreKitConfiguration(strings.NewReader(testStoreKitConfiguration))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Products) != 3 {
+		t.Fatalf("Wrong number of products: %d", len(c.Products))
+	}
+
+	if p, ok := c.Product("coins"); !ok || p.Type != ProductTypeConsumable || p.Price != "0.99" {
+		t.Fatalf("Wrong consumable: %+v", p)
+	}
+
+	if p, ok := c.Product("season"); !ok || p.Type != ProductTypeNonRenewing {
+		t.Fatalf("Wrong non-renewing subscription: %+v", p)
+	}
+
+	p, ok := c.Product("monthly")
+	if !ok {
+		t.Fatal("Subscription should be read")
+	}
+
+	if p.Type != ProductTypeAutoRenewable || p.SubscriptionPeriod != "P1M" || p.SubscriptionGroupID != "21443625" || p.SubscriptionGroupLevel != 1 || !p.FamilyShareable {
+		t.Fatalf("Wrong subscription: %+v", p)
+	}
+
+	if p.IntroductoryOffer == nil || p.IntroductoryOffer.PaymentMode != PaymentModeFreeTrial || p.IntroductoryOffer.Period != "P1W" {
+		t.Fatalf("Wrong introductory offer: %+v", p.IntroductoryOffer)
+	}
+
+	if len(p.PromotionalOffers) != 1 || p.PromotionalOffers[0].ID != "winback" || p.PromotionalOffers[0].NumberOfPeriods != 3 {
+		t.Fatalf("Wrong promotional offers: %+v", p.PromotionalOffers)
+	}
+
+	if len(p.OfferCodes) != 1 || p.OfferCodes[0].ID != "spring" {
+		t.Fatalf("Wrong offer codes: %+v", p.OfferCodes)
+	}
+}
@@ -0,0 +1,484 @@
+package appstore
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	kreceipt "github.com/aktsk/kalvados/receipt"
+)
+
+// ErrAlreadySubscribed is returned when purchasing a subscription which
+// is active
+var ErrAlreadySubscribed = errors.New("already subscribed")
+
+// ErrSubscriptionNotFound is returned when a subscription is not known
+// to the store
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// Expiration intents
+// https://developer.apple.com/documentation/appstorereceipts/expiration_intent
+const (
+	ExpirationIntentCanceled      = "1"
+	ExpirationIntentBillingError  = "2"
+	ExpirationIntentPriceIncrease = "3"
+	ExpirationIntentUnavailable   = "4"
+	ExpirationIntentUnknown       = "5"
+)
+
+// billingRetryPeriod is how long the App Store keeps trying to renew a
+// subscription which failed to renew because of a billing issue
+const billingRetryPeriod = 60 * 24 * time.Hour
+
+// Subscription is an auto-renewable subscription in a store
+type Subscription struct {
+	AccountID              string
+	BundleID               string
+	GroupID                string
+	OriginalTransactionID  string
+	ProductID              string
+	AutoRenewProductID     string
+	AutoRenewStatus        bool
+	ExpiresDate            time.Time
+	ExpirationIntent       string
+	IsInBillingRetryPeriod bool
+	GracePeriodExpiresDate time.Time
+	PriceConsentStatus     string
+
+	latestTransactionID     string
+	offer                   *subscriptionOffer
+	pendingOffer            *subscriptionOffer
+	originalPurchaseDate    time.Time
+	billingRetryExpiresDate time.Time
+	gracePeriodExpired      bool
+}
+
+// Billing is the billing state of a store
+type Billing struct {
+	// BillingIssue makes renewals of subscriptions fail
+	BillingIssue bool `json:"billing_issue"`
+
+	// GracePeriod enables billing grace period
+	GracePeriod bool `json:"grace_period"`
+}
+
+// Purchase purchases a product for an app. Purchasing an
+// auto-renewable subscription starts the subscription, resubscribes to
+// its group if it has expired, or changes the product of the active
+// subscription in the group. An upgrade, or a crossgrade to a product
+// with the same period, takes effect immediately with a new
+// transaction. A downgrade, or a crossgrade to a product with a
+// different period, takes effect at the next renewal, and the current
+// transaction is returned. Purchasing a non-consumable product which
+// has been purchased restores its transaction.
+func (s *Store) Purchase(bundleID, productID string, opts ...PurchaseOption) (*Transaction, error) {
+	o := &purchaseOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	return s.purchase(bundleID, productID, o)
+}
+
+func (s *Store) purchase(bundleID, productID string, o *purchaseOptions) (*Transaction, error) {
+	p, ok := s.products[productID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+
+	now := s.Clock.Now()
+
+	if p.productType() == ProductTypeAutoRenewable {
+		t, err := s.purchaseSubscription(bundleID, p, now, o)
+		if err != nil {
+			return nil, err
+		}
+		copied := *t
+		return &copied, nil
+	}
+
+	if o.promotionalOfferID != "" || o.offerCodeRefName != "" {
+		return nil, ErrOfferNotFound
+	}
+
+	if p.productType() == ProductTypeNonConsumable {
+		for _, t := range s.transactionsOf(o.accountID, bundleID) {
+			if t.ProductID == productID && t.CancellationDate.IsZero() {
+				copied := *t
+				return &copied, nil
+			}
+		}
+	}
+
+	t := &Transaction{
+		AccountID:            o.accountID,
+		BundleID:             bundleID,
+		ProductID:            productID,
+		Quantity:             1,
+		TransactionID:        s.newTransactionID(),
+		PurchaseDate:         now,
+		OriginalPurchaseDate: now,
+		InAppOwnershipType:   OwnershipTypePurchased,
+	}
+	t.OriginalTransactionID = t.TransactionID
+	s.transactions[t.TransactionID] = t
+	s.share(t)
+
+	copied := *t
+	return &copied, nil
+}
+
+func (s *Store) purchaseSubscription(bundleID string, p *Product, now time.Time, o *purchaseOptions) (*Transaction, error) {
+	sub := s.subscriptionOf(o.accountID, bundleID, p.groupID())
+
+	offer, err := s.offerFor(bundleID, p, sub, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if sub == nil {
+		sub = &Subscription{
+			AccountID:            o.accountID,
+			BundleID:             bundleID,
+			GroupID:              p.groupID(),
+			originalPurchaseDate: now,
+			offer:                offer,
+		}
+		t := s.addSubscriptionTransaction(sub, p, now)
+		s.subscriptions[sub.OriginalTransactionID] = sub
+		sub.resetRenewal(p)
+		s.notify(NotificationTypeInitialBuy, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+		return t, nil
+	}
+
+	if !sub.isActive(now) {
+		sub.offer = offer
+		sub.pendingOffer = nil
+		t := s.addSubscriptionTransaction(sub, p, now)
+		sub.resetRenewal(p)
+		s.notify(NotificationTypeInteractiveRenewal, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+		return t, nil
+	}
+
+	current, ok := s.products[sub.ProductID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	latest := s.transactions[sub.latestTransactionID]
+
+	if p.ProductID == current.ProductID {
+		if sub.AutoRenewProductID == p.ProductID && offer == nil {
+			return nil, ErrAlreadySubscribed
+		}
+
+		// Purchasing the current product cancels a pending downgrade,
+		// and an offer for it takes effect at the next renewal
+		sub.resetRenewal(p)
+		sub.pendingOffer = offer
+		s.notify(NotificationTypeDidChangeRenewalPref, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+		return latest, nil
+	}
+
+	upgrade := p.SubscriptionGroupLevel < current.SubscriptionGroupLevel
+	crossgrade := p.SubscriptionGroupLevel == current.SubscriptionGroupLevel
+
+	if !upgrade && !(crossgrade && p.SubscriptionPeriod == current.SubscriptionPeriod) {
+		sub.AutoRenewProductID = p.ProductID
+		sub.AutoRenewStatus = true
+		sub.ExpirationIntent = ""
+		sub.pendingOffer = offer
+		s.notify(NotificationTypeDidChangeRenewalPref, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+		return latest, nil
+	}
+
+	latest.IsUpgraded = true
+	latest.CancellationDate = now
+
+	sub.offer = offer
+	sub.pendingOffer = nil
+	t := s.addSubscriptionTransaction(sub, p, now)
+	sub.resetRenewal(p)
+	s.notify(NotificationTypeInteractiveRenewal, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+
+	return t, nil
+}
+
+// SetAutoRenew turns on or off auto-renew of a subscription
+func (s *Store) SetAutoRenew(originalTransactionID string, autoRenew bool) (*Notification, error) {
+	s.lock()
+	defer s.unlock()
+
+	sub, ok := s.subscriptions[originalTransactionID]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	sub.AutoRenewStatus = autoRenew
+	if autoRenew {
+		sub.ExpirationIntent = ""
+	} else {
+		sub.ExpirationIntent = ExpirationIntentCanceled
+	}
+
+	return s.notify(NotificationTypeDidChangeRenewalStatus, sub.AccountID, sub.BundleID, sub.OriginalTransactionID), nil
+}
+
+// Subscription returns a subscription by its original_transaction_id
+func (s *Store) Subscription(originalTransactionID string) (*Subscription, bool) {
+	s.lock()
+	defer s.unlock()
+
+	sub, ok := s.subscriptions[originalTransactionID]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *sub
+	return &copied, true
+}
+
+// Billing returns the billing state of the store
+func (s *Store) Billing() Billing {
+	s.lock()
+	defer s.unlock()
+
+	return s.billing
+}
+
+// SetBilling sets the billing state of the store. Subscriptions in
+// billing retry period recover when the billing issue is resolved.
+func (s *Store) SetBilling(b Billing) {
+	s.lock()
+	defer s.unlock()
+
+	s.billing = b
+	s.update(s.Clock.Now())
+}
+
+// update renews subscriptions which have expired by now. It must be
+// called with s.mu held.
+func (s *Store) update(now time.Time) {
+	for _, sub := range s.sortedSubscriptions() {
+		s.updateSubscription(sub, now)
+	}
+}
+
+// sortedSubscriptions returns the subscriptions of the store in the
+// order of their original_transaction_id
+func (s *Store) sortedSubscriptions() []*Subscription {
+	subscriptions := []*Subscription{}
+	for _, sub := range s.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].OriginalTransactionID < subscriptions[j].OriginalTransactionID
+	})
+
+	return subscriptions
+}
+
+func (s *Store) updateSubscription(sub *Subscription, now time.Time) {
+	for {
+		p, ok := s.products[sub.AutoRenewProductID]
+		if !ok || p.productType() != ProductTypeAutoRenewable {
+			return
+		}
+
+		if sub.IsInBillingRetryPeriod {
+			if !s.billing.BillingIssue {
+				// Renewal days are kept when the subscription
+				// recovers in grace period
+				renewedAt := now
+				if now.Before(sub.GracePeriodExpiresDate) {
+					renewedAt = sub.ExpiresDate
+				}
+				sub.IsInBillingRetryPeriod = false
+				sub.GracePeriodExpiresDate = time.Time{}
+				sub.ExpirationIntent = ""
+				s.addSubscriptionTransaction(sub, p, renewedAt)
+				s.notify(NotificationTypeDidRecover, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+				continue
+			}
+
+			if !sub.GracePeriodExpiresDate.IsZero() && !sub.gracePeriodExpired && !now.Before(sub.GracePeriodExpiresDate) {
+				sub.gracePeriodExpired = true
+				s.notify(NotificationTypeGracePeriodExpired, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+			}
+
+			if !now.Before(sub.billingRetryExpiresDate) {
+				sub.IsInBillingRetryPeriod = false
+				sub.AutoRenewStatus = false
+			}
+
+			return
+		}
+
+		if now.Before(sub.ExpiresDate) {
+			return
+		}
+
+		if !sub.AutoRenewStatus {
+			if sub.ExpirationIntent == "" {
+				sub.ExpirationIntent = ExpirationIntentCanceled
+			}
+			return
+		}
+
+		// The subscription expires if the customer has not consented
+		// to a price increase by the renewal
+		if sub.PriceConsentStatus == PriceConsentStatusPending {
+			sub.AutoRenewStatus = false
+			sub.ExpirationIntent = ExpirationIntentPriceIncrease
+			return
+		}
+
+		if s.billing.BillingIssue {
+			sub.IsInBillingRetryPeriod = true
+			sub.ExpirationIntent = ExpirationIntentBillingError
+			sub.billingRetryExpiresDate = sub.ExpiresDate.Add(billingRetryPeriod)
+			if s.billing.GracePeriod {
+				sub.GracePeriodExpiresDate = sub.ExpiresDate.Add(p.SubscriptionPeriod.gracePeriod())
+			}
+			s.notify(NotificationTypeDidFailToRenew, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+			continue
+		}
+
+		s.addSubscriptionTransaction(sub, p, sub.ExpiresDate)
+		s.notify(NotificationTypeDidRenew, sub.AccountID, sub.BundleID, sub.OriginalTransactionID)
+	}
+}
+
+// addSubscriptionTransaction adds a transaction of a subscription
+// purchased at t. A pending offer of the subscription takes effect.
+func (s *Store) addSubscriptionTransaction(sub *Subscription, p *Product, t time.Time) *Transaction {
+	if sub.offer == nil && sub.pendingOffer != nil {
+		sub.offer = sub.pendingOffer
+		sub.pendingOffer = nil
+	}
+
+	transaction := &Transaction{
+		AccountID:             sub.AccountID,
+		BundleID:              sub.BundleID,
+		ProductID:             p.ProductID,
+		Quantity:              1,
+		TransactionID:         s.newTransactionID(),
+		OriginalTransactionID: sub.OriginalTransactionID,
+		WebOrderLineItemID:    s.nextID(),
+		PurchaseDate:          t,
+		OriginalPurchaseDate:  sub.originalPurchaseDate,
+		ExpiresDate:           p.SubscriptionPeriod.AddTo(t),
+		SubscriptionGroupID:   p.SubscriptionGroupID,
+		InAppOwnershipType:    OwnershipTypePurchased,
+	}
+
+	if sub.offer != nil {
+		transaction.ExpiresDate = sub.offer.apply(transaction)
+		if sub.offer.remaining <= 0 {
+			sub.offer = nil
+		}
+	}
+
+	if transaction.OriginalTransactionID == "" {
+		transaction.OriginalTransactionID = transaction.TransactionID
+		sub.OriginalTransactionID = transaction.TransactionID
+	}
+
+	s.transactions[transaction.TransactionID] = transaction
+	s.share(transaction)
+
+	sub.ProductID = p.ProductID
+	sub.ExpiresDate = transaction.ExpiresDate
+	sub.PriceConsentStatus = ""
+	sub.latestTransactionID = transaction.TransactionID
+
+	return transaction
+}
+
+// subscriptionOf returns the subscription of a group for an app of
+// an account
+func (s *Store) subscriptionOf(accountID, bundleID, groupID string) *Subscription {
+	for _, sub := range s.subscriptions {
+		if sub.AccountID == accountID && sub.BundleID == bundleID && sub.GroupID == groupID {
+			return sub
+		}
+	}
+	return nil
+}
+
+// subscriptionsOf returns the subscriptions of an app of an account
+func (s *Store) subscriptionsOf(accountID, bundleID string) []*Subscription {
+	subscriptions := []*Subscription{}
+	for _, sub := range s.subscriptions {
+		if sub.AccountID == accountID && sub.BundleID == bundleID {
+			subscriptions = append(subscriptions, sub)
+		}
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].OriginalTransactionID < subscriptions[j].OriginalTransactionID
+	})
+
+	return subscriptions
+}
+
+// resetRenewal makes the subscription renew to a product
+func (sub *Subscription) resetRenewal(p *Product) {
+	sub.AutoRenewProductID = p.ProductID
+	sub.AutoRenewStatus = true
+	sub.ExpirationIntent = ""
+	sub.IsInBillingRetryPeriod = false
+	sub.GracePeriodExpiresDate = time.Time{}
+	sub.gracePeriodExpired = false
+}
+
+// isActive returns whether the subscription is active, including in
+// billing grace period
+func (sub *Subscription) isActive(now time.Time) bool {
+	if now.Before(sub.ExpiresDate) {
+		return true
+	}
+	return sub.IsInBillingRetryPeriod && now.Before(sub.GracePeriodExpiresDate)
+}
+
+func (sub *Subscription) pendingRenewalInfo() *kreceipt.PendingRenewalInfo {
+	info := &kreceipt.PendingRenewalInfo{
+		AutoRenewProductID:    sub.AutoRenewProductID,
+		AutoRenewStatus:       "0",
+		ExpirationIntent:      sub.ExpirationIntent,
+		OriginalTransactionID: sub.OriginalTransactionID,
+		ProductID:             sub.ProductID,
+		PriceConsentStatus:    sub.PriceConsentStatus,
+	}
+
+	if sub.AutoRenewStatus {
+		info.AutoRenewStatus = "1"
+	}
+
+	if sub.IsInBillingRetryPeriod {
+		info.IsInBillingRetryPeriod = "1"
+	} else if sub.ExpirationIntent != "" {
+		info.IsInBillingRetryPeriod = "0"
+	}
+
+	info.GracePeriodExpiresDate, info.GracePeriodExpiresDateMS, info.GracePeriodExpiresDatePST = kreceipt.FormatDate(sub.GracePeriodExpiresDate)
+
+	// An offer applied to the next renewal
+	offer := sub.pendingOffer
+	if offer == nil {
+		offer = sub.offer
+	}
+	if offer != nil {
+		switch offer.kind {
+		case offerPromotional:
+			info.PromotionalOfferID = offer.offer.ID
+		case offerCode:
+			info.OfferCodeRefName = offer.offer.ID
+		}
+	}
+
+	return info
+}
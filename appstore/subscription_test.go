@@ -0,0 +1,460 @@
+package appstore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aktsk/kalvados/clock"
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+const (
+	testBundleID  = "jp.aktsk.kalvados.test"
+	testProductID = "jp.aktsk.kalvados.test.monthly"
+)
+
+func newTestStore(t *testing.T) *Store {
+	c := clock.New()
+	c.Freeze()
+	c.Set(time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC))
+
+	store := New(c)
+	if err := store.AddProduct(Product{ProductID: testProductID, SubscriptionPeriod: "P1M"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func notificationTypes(store *Store) []string {
+	types := []string{}
+	for _, n := range store.Notifications() {
+		types = append(types, n.NotificationType)
+	}
+	return types
+}
+
+func TestRenewal(t *testing.T) {
+	store := newTestStore(t)
+
+	purchased, err := store.Purchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Purchase(testBundleID, testProductID); err != ErrAlreadySubscribed {
+		t.Fatalf("Active subscription should not be purchased: %v", err)
+	}
+
+	store.Clock.Advance(61 * 24 * time.Hour)
+
+	sub, _ := store.Subscription(purchased.OriginalTransactionID)
+	if !sub.ExpiresDate.Equal(time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong expires_date: %v", sub.ExpiresDate)
+	}
+
+	rcpt, err := store.Receipt(testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rcpt.InApp) != 3 {
+		t.Fatalf("Wrong number of transactions: %d", len(rcpt.InApp))
+	}
+
+	renewal := rcpt.InApp[2]
+	if renewal.OriginalTransactionID != purchased.TransactionID {
+		t.Fatalf("Wrong original_transaction_id: %s", renewal.OriginalTransactionID)
+	}
+
+	if !time.Time(renewal.PurchaseDate.Date).Equal(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong purchase_date: %v", time.Time(renewal.PurchaseDate.Date))
+	}
+
+	types := notificationTypes(store)
+	if len(types) != 3 || types[0] != NotificationTypeInitialBuy || types[2] != NotificationTypeDidRenew {
+		t.Fatalf("Wrong notifications: %v", types)
+	}
+
+	if _, err := store.SetAutoRenew(purchased.OriginalTransactionID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Clock.Advance(31 * 24 * time.Hour)
+
+	sub, _ = store.Subscription(purchased.OriginalTransactionID)
+	if sub.ExpirationIntent != ExpirationIntentCanceled {
+		t.Fatalf("Wrong expiration_intent: %s", sub.ExpirationIntent)
+	}
+
+	if _, err := store.Purchase(testBundleID, testProductID); err != nil {
+		t.Fatalf("Expired subscription should be purchased: %v", err)
+	}
+
+	types = notificationTypes(store)
+	if types[len(types)-1] != NotificationTypeInteractiveRenewal {
+		t.Fatalf("Wrong notifications: %v", types)
+	}
+}
+
+func TestBillingRetry(t *testing.T) {
+	store := newTestStore(t)
+
+	purchased, err := store.Purchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.SetBilling(Billing{BillingIssue: true, GracePeriod: true})
+	store.Clock.Advance(31 * 24 * time.Hour)
+
+	sub, _ := store.Subscription(purchased.OriginalTransactionID)
+	if !sub.IsInBillingRetryPeriod || sub.ExpirationIntent != ExpirationIntentBillingError {
+		t.Fatalf("Subscription should be in billing retry period: %v", sub)
+	}
+
+	if !sub.GracePeriodExpiresDate.Equal(time.Date(2018, 5, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong grace_period_expires_date: %v", sub.GracePeriodExpiresDate)
+	}
+
+	rcpt, err := store.Receipt(testBundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := store.Verify(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := res.PendingRenewalInfo[0]
+	if info.IsInBillingRetryPeriod != "1" || info.ExpirationIntent != "2" || info.GracePeriodExpiresDate != "2018-05-17 00:00:00 Etc/GMT" {
+		t.Fatalf("Wrong pending_renewal_info: %v", info)
+	}
+
+	store.Clock.Advance(17 * 24 * time.Hour)
+	store.SetBilling(Billing{GracePeriod: true})
+
+	sub, _ = store.Subscription(purchased.OriginalTransactionID)
+	if sub.IsInBillingRetryPeriod || !sub.ExpiresDate.Equal(time.Date(2018, 6, 19, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Subscription should recover from the date of payment: %v", sub)
+	}
+
+	types := notificationTypes(store)
+	expected := []string{
+		NotificationTypeInitialBuy,
+		NotificationTypeDidFailToRenew,
+		NotificationTypeGracePeriodExpired,
+		NotificationTypeDidRecover,
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("Wrong notifications: %v", types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatalf("Wrong notifications: %v", types)
+		}
+	}
+}
+
+func TestRecoverInGracePeriod(t *testing.T) {
+	store := newTestStore(t)
+
+	purchased, err := store.Purchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.SetBilling(Billing{BillingIssue: true, GracePeriod: true})
+	store.Clock.Advance(35 * 24 * time.Hour)
+	store.SetBilling(Billing{GracePeriod: true})
+
+	sub, _ := store.Subscription(purchased.OriginalTransactionID)
+	if sub.IsInBillingRetryPeriod || !sub.ExpiresDate.Equal(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Subscription should keep its renewal date: %v", sub)
+	}
+}
+
+func TestBillingRetryExpired(t *testing.T) {
+	store := newTestStore(t)
+
+	purchased, err := store.Purchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.SetBilling(Billing{BillingIssue: true})
+	store.Clock.Advance(100 * 24 * time.Hour)
+	store.SetBilling(Billing{})
+
+	sub, _ := store.Subscription(purchased.OriginalTransactionID)
+	if sub.IsInBillingRetryPeriod || sub.AutoRenewStatus || sub.ExpirationIntent != ExpirationIntentBillingError {
+		t.Fatalf("Subscription should expire after billing retry period: %v", sub)
+	}
+
+	if !sub.GracePeriodExpiresDate.IsZero() {
+		t.Fatalf("Grace period should not be set: %v", sub.GracePeriodExpiresDate)
+	}
+}
+
+func TestUpgradeAndDowngrade(t *testing.T) {
+	store := newTestStore(t)
+
+	products := []Product{
+		{ProductID: "premium", SubscriptionPeriod: "P1M", SubscriptionGroupID: "group", SubscriptionGroupLevel: 1},
+		{ProductID: "standard", SubscriptionPeriod: "P1M", SubscriptionGroupID: "group", SubscriptionGroupLevel: 2},
+		{ProductID: "standard.yearly", SubscriptionPeriod: "P1Y", SubscriptionGroupID: "group", SubscriptionGroupLevel: 2},
+	}
+	for _, p := range products {
+		if err := store.AddProduct(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.AddProduct(Product{ProductID: "basic", SubscriptionPeriod: "P1M", SubscriptionGroupID: "group"}); err != ErrInvalidGroupLevel {
+		t.Fatalf("Subscription in a group without a level should not be added: %v", err)
+	}
+
+	standard, err := store.Purchase(testBundleID, "standard")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Clock.Advance(10 * 24 * time.Hour)
+
+	premium, err := store.Purchase(testBundleID, "premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if premium.OriginalTransactionID != standard.TransactionID {
+		t.Fatalf("Upgrade should keep original_transaction_id: %s", premium.OriginalTransactionID)
+	}
+
+	if !premium.ExpiresDate.Equal(time.Date(2018, 5, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Upgrade should take effect immediately: %v", premium.ExpiresDate)
+	}
+
+	upgraded, _ := store.Transaction(standard.TransactionID)
+	if !upgraded.IsUpgraded || !upgraded.CancellationDate.Equal(store.Clock.Now()) {
+		t.Fatalf("Upgraded transaction should be cancelled: %v", upgraded)
+	}
+
+	current, err := store.Purchase(testBundleID, "standard.yearly")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current.TransactionID != premium.TransactionID {
+		t.Fatalf("Downgrade should not create a transaction: %s", current.TransactionID)
+	}
+
+	sub, _ := store.Subscription(standard.TransactionID)
+	if sub.ProductID != "premium" || sub.AutoRenewProductID != "standard.yearly" {
+		t.Fatalf("Downgrade should take effect at next renewal: %s, %s", sub.ProductID, sub.AutoRenewProductID)
+	}
+
+	store.Clock.Advance(31 * 24 * time.Hour)
+
+	sub, _ = store.Subscription(standard.TransactionID)
+	if sub.ProductID != "standard.yearly" || !sub.ExpiresDate.Equal(time.Date(2019, 5, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Subscription should be renewed with the downgraded product: %s, %v", sub.ProductID, sub.ExpiresDate)
+	}
+
+	types := notificationTypes(store)
+	expected := []string{
+		NotificationTypeInitialBuy,
+		NotificationTypeInteractiveRenewal,
+		NotificationTypeDidChangeRenewalPref,
+		NotificationTypeDidRenew,
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("Wrong notifications: %v", types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatalf("Wrong notifications: %v", types)
+		}
+	}
+}
+
+func TestChangeSubscriptionOfUnknownProduct(t *testing.T) {
+	store := newTestStore(t)
+
+	products := []Product{
+		{ProductID: "premium", SubscriptionPeriod: "P1M", SubscriptionGroupID: "group", SubscriptionGroupLevel: 1},
+		{ProductID: "standard", SubscriptionPeriod: "P1M", SubscriptionGroupID: "group", SubscriptionGroupLevel: 2},
+	}
+	for _, p := range products {
+		if err := store.AddProduct(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.Purchase(testBundleID, "standard"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The product of the active subscription is no longer known
+	delete(store.products, "standard")
+
+	if _, err := store.Purchase(testBundleID, "premium"); err != ErrProductNotFound {
+		t.Fatalf("Subscription of an unknown product should not be changed: %v", err)
+	}
+}
+
+func TestIntroductoryOffer(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.AddProduct(Product{
+		ProductID:          testProductID,
+		SubscriptionPeriod: "P1M",
+		IntroductoryOffer:  &Offer{PaymentMode: PaymentModePayAsYouGo, Period: "P1M", NumberOfPeriods: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purchased, err := store.Purchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Clock.Advance(92 * 24 * time.Hour)
+	store.Update()
+
+	transactions := store.transactionsOf("", testBundleID)
+	if len(transactions) != 4 {
+		t.Fatalf("Wrong number of transactions: %d", len(transactions))
+	}
+
+	for i, intro := range []bool{false, false, true, true} {
+		if transactions[i].IsInIntroOfferPeriod != intro {
+			t.Fatalf("Wrong is_in_intro_offer_period of transaction %d: %v", i, transactions[i].IsInIntroOfferPeriod)
+		}
+	}
+
+	// Resubscribing is not eligible for the introductory offer
+	if _, err := store.SetAutoRenew(purchased.OriginalTransactionID, false); err != nil {
+		t.Fatal(err)
+	}
+	store.Clock.Advance(31 * 24 * time.Hour)
+
+	t2, err := store.Purchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if t2.IsInIntroOfferPeriod || t2.IsTrialPeriod {
+		t.Fatalf("Resubscription should not have the introductory offer: %+v", t2)
+	}
+}
+
+func TestIntroductoryOfferOfRecordedTransactions(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.AddProduct(Product{
+		ProductID:          testProductID,
+		SubscriptionPeriod: "P1M",
+		IntroductoryOffer:  &Offer{PaymentMode: PaymentModeFreeTrial, Period: "P1W"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt := receipt.Receipt{}
+	err = json.Unmarshal([]byte(`{
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "in_app": [
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000001",
+      "original_transaction_id": "1000000000000001",
+      "is_trial_period": "true",
+      "purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-01-08 00:00:00 Etc/GMT"
+    }
+  ]
+}`), &rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Record(&rcpt)
+
+	purchased, err := store.Purchase(testBundleID, testProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purchased.IsTrialPeriod {
+		t.Fatal("Customer who has had a free trial should not be eligible for the introductory offer")
+	}
+}
+
+func TestPromotionalOfferAndOfferCode(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.AddProduct(Product{
+		ProductID:          testProductID,
+		SubscriptionPeriod: "P1M",
+		PromotionalOffers:  []Offer{{ID: "winback", PaymentMode: PaymentModeFreeTrial, Period: "P1W"}},
+		OfferCodes:         []Offer{{ID: "spring", PaymentMode: PaymentModePayUpFront, Period: "P1M", NumberOfPeriods: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Purchase(testBundleID, testProductID, WithPromotionalOffer("winback")); err != ErrNotEligible {
+		t.Fatalf("New customer should not be eligible for promotional offers: %v", err)
+	}
+
+	if _, err := store.Purchase(testBundleID, testProductID, WithOfferCode("unknown")); err != ErrOfferNotFound {
+		t.Fatalf("Unknown offer code should not be redeemed: %v", err)
+	}
+
+	purchased, err := store.Purchase(testBundleID, testProductID, WithOfferCode("spring"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purchased.OfferCodeRefName != "spring" {
+		t.Fatalf("Wrong offer_code_ref_name: %s", purchased.OfferCodeRefName)
+	}
+	if !purchased.ExpiresDate.Equal(time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong expires_date: %v", purchased.ExpiresDate)
+	}
+
+	if _, err := store.Purchase(testBundleID, testProductID, WithOfferCode("spring")); err != ErrNotEligible {
+		t.Fatalf("Offer code should not be redeemed twice: %v", err)
+	}
+
+	other, err := store.Purchase(testBundleID, testProductID, WithOfferCode("spring"), WithAccount("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.OfferCodeRefName != "spring" {
+		t.Fatalf("Offer code should be redeemed by another account: %s", other.OfferCodeRefName)
+	}
+
+	// A promotional offer for an active subscription applies at the
+	// next renewal
+	if _, err := store.Purchase(testBundleID, testProductID, WithPromotionalOffer("winback")); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, _ := store.Subscription(purchased.OriginalTransactionID)
+	if info := sub.pendingRenewalInfo(); info.PromotionalOfferID != "winback" {
+		t.Fatalf("Wrong promotional_offer_id of pending renewal: %s", info.PromotionalOfferID)
+	}
+
+	store.Clock.Set(time.Date(2018, 7, 2, 0, 0, 0, 0, time.UTC))
+	store.Update()
+
+	renewal := store.transactionsOf("", testBundleID)[0]
+	if renewal.PromotionalOfferID != "winback" || !renewal.IsTrialPeriod {
+		t.Fatalf("Renewal should have the promotional offer: %+v", renewal)
+	}
+	if !renewal.ExpiresDate.Equal(time.Date(2018, 7, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong expires_date: %v", renewal.ExpiresDate)
+	}
+}
@@ -0,0 +1,131 @@
+package appstore
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	kreceipt "github.com/aktsk/kalvados/receipt"
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+// Transaction is an in-app purchase transaction known to a store
+type Transaction struct {
+	AccountID             string
+	BundleID              string
+	ProductID             string
+	Quantity              int64
+	TransactionID         string
+	OriginalTransactionID string
+	WebOrderLineItemID    int64
+	PurchaseDate          time.Time
+	OriginalPurchaseDate  time.Time
+	ExpiresDate           time.Time
+	CancellationDate      time.Time
+	CancellationReason    string
+	IsTrialPeriod         bool
+	IsInIntroOfferPeriod  bool
+	IsUpgraded            bool
+	SubscriptionGroupID   string
+	PromotionalOfferID    string
+	OfferCodeRefName      string
+	InAppOwnershipType    string
+
+	// Finished is whether the app has finished the transaction.
+	// Finished consumable transactions are removed from receipts.
+	Finished bool
+
+	// sharedFrom is the transaction ID of the organizer's transaction
+	// for a transaction shared with Family Sharing
+	sharedFrom string
+}
+
+func newTransaction(bundleID string, inApp *receipt.InApp) *Transaction {
+	isTrialPeriod, _ := strconv.ParseBool(inApp.IsTrialPeriod)
+
+	return &Transaction{
+		BundleID:              bundleID,
+		ProductID:             inApp.ProductID,
+		Quantity:              inApp.Quantity,
+		TransactionID:         inApp.TransactionID,
+		OriginalTransactionID: inApp.OriginalTransactionID,
+		WebOrderLineItemID:    inApp.WebOrderLineItemID,
+		PurchaseDate:          time.Time(inApp.PurchaseDate.Date),
+		OriginalPurchaseDate:  time.Time(inApp.OriginalPurchaseDate.Date),
+		ExpiresDate:           time.Time(inApp.ExpiresDate.Date),
+		CancellationDate:      time.Time(inApp.CancellationDate.Date),
+		CancellationReason:    inApp.CancellationReason,
+		IsTrialPeriod:         isTrialPeriod,
+		IsInIntroOfferPeriod:  inApp.IsInIntroPrice,
+	}
+}
+
+// InApp returns the transaction as an in-app purchase receipt
+func (t *Transaction) InApp() (*receipt.InApp, error) {
+	inApp := &receipt.InApp{
+		Quantity:              t.Quantity,
+		ProductID:             t.ProductID,
+		TransactionID:         t.TransactionID,
+		OriginalTransactionID: t.OriginalTransactionID,
+		WebOrderLineItemID:    t.WebOrderLineItemID,
+		IsTrialPeriod:         strconv.FormatBool(t.IsTrialPeriod),
+		CancellationReason:    t.CancellationReason,
+		IsInIntroPrice:        t.IsInIntroOfferPeriod,
+	}
+
+	dates := []struct {
+		field json.Unmarshaler
+		t     time.Time
+	}{
+		{&inApp.PurchaseDate.Date, t.PurchaseDate},
+		{&inApp.OriginalPurchaseDate.Date, t.OriginalPurchaseDate},
+		{&inApp.ExpiresDate.Date, t.ExpiresDate},
+		{&inApp.CancellationDate.Date, t.CancellationDate},
+	}
+
+	for _, d := range dates {
+		if d.t.IsZero() {
+			continue
+		}
+		if err := kreceipt.SetDate(d.field, d.t); err != nil {
+			return nil, err
+		}
+	}
+
+	return inApp, nil
+}
+
+// responseInApp returns the transaction as an in-app purchase
+// transaction in a verifyReceipt style response, which has the fields
+// only the store knows, like is_upgraded
+func (t *Transaction) responseInApp() *kreceipt.ResponseInApp {
+	ri := kreceipt.NewResponseInApp(&kreceipt.InApp{
+		Quantity:              t.Quantity,
+		ProductID:             t.ProductID,
+		TransactionID:         t.TransactionID,
+		OriginalTransactionID: t.OriginalTransactionID,
+		PurchaseDate:          kreceipt.Date{Time: t.PurchaseDate},
+		OriginalPurchaseDate:  kreceipt.Date{Time: t.OriginalPurchaseDate},
+		ExpiresDate:           kreceipt.Date{Time: t.ExpiresDate},
+		WebOrderLineItemID:    t.WebOrderLineItemID,
+		IsTrialPeriod:         strconv.FormatBool(t.IsTrialPeriod),
+		IsInIntroOfferPeriod:  strconv.FormatBool(t.IsInIntroOfferPeriod),
+		CancellationDate:      kreceipt.Date{Time: t.CancellationDate},
+		CancellationReason:    t.CancellationReason,
+	})
+
+	ri.SubscriptionGroupIdentifier = t.SubscriptionGroupID
+	ri.PromotionalOfferID = t.PromotionalOfferID
+	ri.OfferCodeRefName = t.OfferCodeRefName
+
+	ri.InAppOwnershipType = t.InAppOwnershipType
+	if ri.InAppOwnershipType == "" {
+		ri.InAppOwnershipType = OwnershipTypePurchased
+	}
+
+	if t.IsUpgraded {
+		ri.IsUpgraded = "true"
+	}
+
+	return ri
+}
@@ -0,0 +1,124 @@
+// Package clock provides a virtual clock which can be set, frozen and
+// advanced independently of the wall clock.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock. A clock created with a parent follows the
+// parent until it is set, frozen or advanced by itself.
+type Clock struct {
+	mu       sync.Mutex
+	parent   *Clock
+	detached bool
+	offset   time.Duration
+	frozen   bool
+	frozenAt time.Time
+}
+
+// State is a snapshot of a clock
+type State struct {
+	Now    time.Time
+	Frozen bool
+}
+
+// New returns a clock which follows the wall clock
+func New() *Clock {
+	return &Clock{detached: true}
+}
+
+// NewChild returns a clock which follows the parent clock
+func NewChild(parent *Clock) *Clock {
+	return &Clock{parent: parent}
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now()
+}
+
+// State returns the current state of the clock
+func (c *Clock) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.detached {
+		return c.parent.State()
+	}
+
+	return State{Now: c.now(), Frozen: c.frozen}
+}
+
+// Set sets the current time of the clock. A frozen clock stays frozen
+// at the given time.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(t, c.isFrozen())
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now().Add(d), c.isFrozen())
+}
+
+// Freeze stops the clock at the current time
+func (c *Clock) Freeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now(), true)
+}
+
+// Unfreeze restarts the clock from the time it was frozen at
+func (c *Clock) Unfreeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now(), false)
+}
+
+// Reset makes the clock follow the wall clock again, or its parent if
+// it has one.
+func (c *Clock) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.detached = c.parent == nil
+	c.offset = 0
+	c.frozen = false
+	c.frozenAt = time.Time{}
+}
+
+func (c *Clock) now() time.Time {
+	if !c.detached {
+		return c.parent.Now()
+	}
+	if c.frozen {
+		return c.frozenAt
+	}
+	return time.Now().Add(c.offset)
+}
+
+func (c *Clock) isFrozen() bool {
+	if !c.detached {
+		return c.parent.State().Frozen
+	}
+	return c.frozen
+}
+
+func (c *Clock) set(t time.Time, frozen bool) {
+	c.detached = true
+	c.frozen = frozen
+	if frozen {
+		c.frozenAt = t
+		c.offset = 0
+	} else {
+		c.frozenAt = time.Time{}
+		c.offset = t.Sub(time.Now())
+	}
+}
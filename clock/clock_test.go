@@ -0,0 +1,72 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	c := New()
+
+	if d := time.Since(c.Now()); d < 0 || d > time.Minute {
+		t.Fatalf("Clock does not follow the wall clock: %v", c.Now())
+	}
+
+	at := time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)
+	c.Freeze()
+	c.Set(at)
+
+	if !c.Now().Equal(at) {
+		t.Fatalf("Wrong frozen time: %v", c.Now())
+	}
+
+	c.Advance(24 * time.Hour)
+	if !c.Now().Equal(at.AddDate(0, 0, 1)) {
+		t.Fatalf("Wrong advanced time: %v", c.Now())
+	}
+
+	if !c.State().Frozen {
+		t.Fatal("Clock should stay frozen after advanced")
+	}
+
+	c.Unfreeze()
+	if c.State().Frozen {
+		t.Fatal("Clock should not be frozen")
+	}
+
+	if d := c.Now().Sub(at.AddDate(0, 0, 1)); d < 0 || d > time.Minute {
+		t.Fatalf("Clock should restart from the frozen time: %v", c.Now())
+	}
+
+	c.Reset()
+	if d := time.Since(c.Now()); d < 0 || d > time.Minute {
+		t.Fatalf("Clock should follow the wall clock after reset: %v", c.Now())
+	}
+}
+
+func TestChild(t *testing.T) {
+	parent := New()
+	child := NewChild(parent)
+
+	at := time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)
+	parent.Freeze()
+	parent.Set(at)
+
+	if !child.Now().Equal(at) || !child.State().Frozen {
+		t.Fatalf("Child does not follow the parent: %v", child.State())
+	}
+
+	child.Advance(time.Hour)
+	if !child.Now().Equal(at.Add(time.Hour)) {
+		t.Fatalf("Wrong advanced time: %v", child.Now())
+	}
+
+	if !parent.Now().Equal(at) {
+		t.Fatalf("Parent should not be advanced: %v", parent.Now())
+	}
+
+	child.Reset()
+	if !child.Now().Equal(at) {
+		t.Fatalf("Child should follow the parent after reset: %v", child.Now())
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 
+	"github.com/aktsk/kalvados/appstore"
 	"github.com/aktsk/kalvados/server"
 	"github.com/aktsk/kalvados/version"
 )
@@ -19,16 +21,22 @@ var GitCommit string
 
 func main() {
 	var (
-		port         int
-		keyFileName  string
-		certFileName string
-		versionFlag  bool
+		port            int
+		keyFileName     string
+		certFileName    string
+		versionFlag     bool
+		notificationURL string
+		catalogFileName string
+		strict          bool
 	)
 
 	flag.IntVar(&port, "port", 8000, "Port to listen")
 	flag.StringVar(&keyFileName, "keyFile", "key.pem", "Private Key file")
 	flag.StringVar(&certFileName, "certFile", "cert.pem", "Cetificate file")
 	flag.BoolVar(&versionFlag, "version", false, "print version string")
+	flag.StringVar(&notificationURL, "notificationURL", "", "URL to post App Store server notifications to")
+	flag.StringVar(&catalogFileName, "catalogFile", "", "Product catalog file in JSON or .storekit")
+	flag.BoolVar(&strict, "strict", false, "Reject JSON receipt data with unknown fields unless strict=false is given")
 
 	flag.Parse()
 
@@ -73,5 +81,20 @@ func main() {
 		log.Fatal(err)
 	}
 
-	server.Serve(port, key, cert)
+	s := server.New(key, cert)
+	s.NotificationURL = notificationURL
+	s.Strict = strict
+
+	if catalogFileName != "" {
+		catalog, err := appstore.OpenCatalog(catalogFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := s.SetCatalog(catalog); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), s))
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// diff prints the differences between two receipt data files. Like
+// diff(1), it exits with 0 if they are the same, 1 if they differ and 2
+// on trouble.
+func diff(args []string) {
+	var format string
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&format, "format", "text", "Output format, text or json")
+
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		log.Print("Usage: kalvados diff [-format text|json] a.txt b.txt")
+		os.Exit(2)
+	}
+
+	receiptData := []string{}
+	for _, fileName := range fs.Args() {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		receiptData = append(receiptData, strings.TrimSpace(string(data)))
+	}
+
+	changes, err := receipt.Diff(receiptData[0], receiptData[1])
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+
+	switch format {
+	case "text":
+		receipt.WriteChanges(os.Stdout, changes)
+	case "json":
+		out, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		fmt.Println(string(out))
+	default:
+		log.Printf("Unknown format: %s", format)
+		os.Exit(2)
+	}
+
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
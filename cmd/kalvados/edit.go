@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// edit applies patches to the receipt data read from stdin, and prints
+// the receipt re-signed with the key and the certificate
+func edit(args []string) {
+	var (
+		keyFileName      string
+		certFileName     string
+		patchFileName    string
+		addInAppFileName string
+		indefiniteLength bool
+	)
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&keyFileName, "keyFile", "key.pem", "Private Key file")
+	fs.StringVar(&certFileName, "certFile", "cert.pem", "Cetificate file")
+	fs.StringVar(&patchFileName, "patchFile", "", "JSON Patch file to apply to the JSON receipt data")
+	fs.StringVar(&addInAppFileName, "addInAppFile", "", "JSON file of an in-app purchase receipt to add")
+	fs.BoolVar(&indefiniteLength, "indefiniteLength", false, "Encode the PKCS#7 container in BER with indefinite lengths")
+
+	fs.Parse(args)
+
+	key, cert := loadKeyAndCert(keyFileName, certFileName)
+
+	receiptData, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	patches := []receipt.Patch{}
+
+	if patchFileName != "" {
+		patchJSON, err := ioutil.ReadFile(patchFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := json.Unmarshal(patchJSON, &patches); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if addInAppFileName != "" {
+		inAppJSON, err := ioutil.ReadFile(addInAppFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		patches = append(patches, receipt.Patch{Op: "add", Path: "/in_app/-", Value: inAppJSON})
+	}
+
+	rcpt, err := receipt.Resign(strings.TrimSpace(string(receiptData)), key, cert, patches, receipt.WithIndefiniteLength(indefiniteLength))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(rcpt)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// fuzz writes mutants of the receipt data read from stdin to a
+// directory, one receipt per file
+func fuzz(args []string) {
+	var (
+		keyFileName  string
+		certFileName string
+		outDir       string
+		seed         int64
+		n            int
+		mutations    string
+		resign       bool
+	)
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&keyFileName, "keyFile", "key.pem", "Private Key file")
+	fs.StringVar(&certFileName, "certFile", "cert.pem", "Cetificate file")
+	fs.StringVar(&outDir, "outDir", ".", "Directory to write the mutants to")
+	fs.Int64Var(&seed, "seed", 0, "Seed of the random mutations")
+	fs.IntVar(&n, "n", 100, "Number of mutants")
+	fs.StringVar(&mutations, "mutations", "", "Comma separated mutations to choose from: "+strings.Join(receipt.Mutations, ", "))
+	fs.BoolVar(&resign, "resign", false, "Re-sign mutated payloads")
+
+	fs.Parse(args)
+
+	receiptData, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config := receipt.FuzzConfig{Seed: seed, N: n}
+	if mutations != "" {
+		config.Mutations = strings.Split(mutations, ",")
+	}
+	if resign {
+		config.Key, config.Cert = loadKeyAndCert(keyFileName, certFileName)
+	}
+
+	mutants, err := receipt.Fuzz(strings.TrimSpace(string(receiptData)), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	for i, m := range mutants {
+		fileName := filepath.Join(outDir, fmt.Sprintf("%04d-%s.txt", i+1, m.Mutation))
+		if err := ioutil.WriteFile(fileName, []byte(m.ReceiptData+"\n"), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
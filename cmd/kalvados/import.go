@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// importReceipt prints JSON receipt data of the verifyReceipt response
+// read from stdin. Attributes of base64 encoded receipt data are kept
+// unless the receipt is anonymized.
+func importReceipt(args []string) {
+	var anonymizeKey string
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&anonymizeKey, "anonymizeKey", "", "Key to anonymize IDs, the bundle ID and dates with. Not anonymized if empty")
+
+	fs.Parse(args)
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rcpt, attributes, err := receipt.Import(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if anonymizeKey != "" {
+		receipt.NewAnonymizer([]byte(anonymizeKey)).Anonymize(rcpt)
+		// Unknown attributes are not anonymized
+		attributes = nil
+	}
+
+	receiptJSON, err := receipt.Marshal(rcpt, attributes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(receiptJSON))
+}
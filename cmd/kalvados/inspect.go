@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// inspect prints what is in the receipt data read from stdin
+func inspect(args []string) {
+	var format string
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&format, "format", "text", "Output format, text or json")
+
+	fs.Parse(args)
+
+	receiptData, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := receipt.Inspect(strings.TrimSpace(string(receiptData)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch format {
+	case "text":
+		report.WriteText(os.Stdout)
+	case "json":
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	default:
+		log.Fatalf("Unknown format: %s", format)
+	}
+}
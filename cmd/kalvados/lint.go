@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// lint prints the violations of JSON receipt data files, or stdin if no
+// files are given. It exits with 1 if there are violations.
+func lint(args []string) {
+	var format string
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&format, "format", "text", "Output format, text or json")
+
+	fs.Parse(args)
+
+	fileNames := fs.Args()
+	if len(fileNames) == 0 {
+		fileNames = []string{"-"}
+	}
+
+	results := map[string][]*receipt.Violation{}
+	found := false
+
+	for _, fileName := range fileNames {
+		var receiptJSON []byte
+		var err error
+		if fileName == "-" {
+			receiptJSON, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			receiptJSON, err = ioutil.ReadFile(fileName)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		violations, err := receipt.Validate(receiptJSON)
+		if err != nil {
+			log.Fatalf("%s: %v", fileName, err)
+		}
+
+		results[fileName] = violations
+		found = found || len(violations) > 0
+	}
+
+	switch format {
+	case "text":
+		for _, fileName := range fileNames {
+			for _, v := range results[fileName] {
+				fmt.Printf("%s:%s: %s\n", fileName, v.Path, v.Message)
+			}
+		}
+	case "json":
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	default:
+		log.Fatalf("Unknown format: %s", format)
+	}
+
+	if found {
+		os.Exit(1)
+	}
+}
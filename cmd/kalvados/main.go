@@ -1,15 +1,18 @@
 package main
 
 import (
-	"bufio"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
+	"github.com/aktsk/kalvados/appstore"
 	"github.com/aktsk/kalvados/receipt"
 	"github.com/aktsk/kalvados/version"
 )
@@ -19,15 +22,63 @@ const name = "kalvados"
 var GitCommit string
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "refund":
+			refund(os.Args[2:], false)
+			return
+		case "revoke":
+			refund(os.Args[2:], true)
+			return
+		case "scenario":
+			runScenario(os.Args[2:])
+			return
+		case "fuzz":
+			fuzz(os.Args[2:])
+			return
+		case "random":
+			random(os.Args[2:])
+			return
+		case "edit":
+			edit(os.Args[2:])
+			return
+		case "import":
+			importReceipt(os.Args[2:])
+			return
+		case "inspect":
+			inspect(os.Args[2:])
+			return
+		case "diff":
+			diff(os.Args[2:])
+			return
+		case "lint":
+			lint(os.Args[2:])
+			return
+		}
+	}
+
 	var (
-		keyFileName  string
-		certFileName string
-		versionFlag  bool
+		keyFileName      string
+		certFileName     string
+		versionFlag      bool
+		now              string
+		catalogFileName  string
+		responseFileName string
+		appleEncoding    bool
+		indefiniteLength bool
+		strict           bool
 	)
 
 	flag.StringVar(&keyFileName, "keyFile", "key.pem", "Private Key file")
 	flag.StringVar(&certFileName, "certFile", "cert.pem", "Cetificate file")
 	flag.BoolVar(&versionFlag, "version", false, "print version string")
+	flag.StringVar(&now, "now", "", "Current time in RFC 3339 format used for omitted dates")
+	flag.StringVar(&catalogFileName, "catalogFile", "", "Product catalog file in JSON or .storekit to validate the receipt against")
+
+	flag.StringVar(&responseFileName, "responseFile", "", "File to write the verifyReceipt style response to")
+	flag.BoolVar(&appleEncoding, "appleEncoding", true, "Encode in the ASN.1 structure of the App Store")
+	flag.BoolVar(&indefiniteLength, "indefiniteLength", false, "Encode the PKCS#7 container in BER with indefinite lengths")
+	flag.BoolVar(&strict, "strict", true, "Fail on unknown fields and warn on fields not reflected in the receipt")
 
 	flag.Parse()
 
@@ -36,6 +87,76 @@ func main() {
 		os.Exit(0)
 	}
 
+	key, cert := loadKeyAndCert(keyFileName, certFileName)
+
+	receiptJSON, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if strict {
+		warnings, err := receipt.CheckFields(receiptJSON, receipt.WithAppleEncoding(appleEncoding))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, w := range warnings {
+			log.Print(w)
+		}
+	}
+
+	rcpt, err := receipt.UnmarshalReceipt(receiptJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	attributes, err := receipt.UnmarshalAttributes(receiptJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if catalogFileName != "" {
+		nrcpt, err := rcpt.Nolmandy()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := loadCatalog(catalogFileName).ValidateReceipt(nrcpt); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	opts := []receipt.Option{
+		receipt.WithNow(nowFunc(now)),
+		receipt.WithAppleEncoding(appleEncoding),
+		receipt.WithIndefiniteLength(indefiniteLength),
+		receipt.WithAttributes(attributes),
+	}
+
+	var res receipt.Response
+	if responseFileName != "" {
+		opts = append(opts, receipt.WithResponse(&res))
+	}
+
+	encodedReceipt, err := rcpt.Encode(key, cert, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if responseFileName != "" {
+		response, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(responseFileName, response, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Println(encodedReceipt)
+}
+
+func loadKeyAndCert(keyFileName, certFileName string) (*rsa.PrivateKey, *x509.Certificate) {
 	keyFile, err := os.Open(keyFileName)
 	if err != nil {
 		log.Fatal(err)
@@ -72,10 +193,29 @@ func main() {
 		log.Fatal(err)
 	}
 
-	stdin := bufio.NewScanner(os.Stdin)
-	stdin.Scan()
+	return key, cert
+}
+
+func loadCatalog(catalogFileName string) *appstore.Catalog {
+	catalog, err := appstore.OpenCatalog(catalogFileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return catalog
+}
+
+// nowFunc returns a function which returns the time given by -now
+// flag, or the current time if it is not given
+func nowFunc(now string) func() time.Time {
+	if now == "" {
+		return time.Now
+	}
 
-	encodedReceipt, _ := receipt.Encode(stdin.Bytes(), key, cert)
+	t, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	fmt.Println(encodedReceipt)
+	return func() time.Time { return t }
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// random writes random receipts and their verifyReceipt style responses
+// to a directory
+func random(args []string) {
+	var (
+		keyFileName     string
+		certFileName    string
+		outDir          string
+		seed            int64
+		n               int
+		bundleID        string
+		catalogFileName string
+		start           string
+		end             string
+		minTransactions int
+		maxTransactions int
+	)
+
+	d := receipt.DefaultConstraints()
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&keyFileName, "keyFile", "key.pem", "Private Key file")
+	fs.StringVar(&certFileName, "certFile", "cert.pem", "Cetificate file")
+	fs.StringVar(&outDir, "outDir", ".", "Directory to write the receipts to")
+	fs.Int64Var(&seed, "seed", 0, "Seed of the random receipts")
+	fs.IntVar(&n, "n", 100, "Number of receipts")
+	fs.StringVar(&bundleID, "bundleID", d.BundleID, "Bundle ID of the receipts")
+	fs.StringVar(&catalogFileName, "catalogFile", "", "Product catalog file in JSON or .storekit to purchase products from")
+	fs.StringVar(&start, "start", d.Start.Format(time.RFC3339), "Start of the dates of the receipts in RFC 3339 format")
+	fs.StringVar(&end, "end", d.End.Format(time.RFC3339), "End of the dates of the receipts in RFC 3339 format")
+	fs.IntVar(&minTransactions, "minTransactions", d.MinTransactions, "Minimum number of transactions of a receipt, which needs consumable or non-renewing products")
+	fs.IntVar(&maxTransactions, "maxTransactions", d.MaxTransactions, "Maximum number of transactions of a receipt")
+
+	fs.Parse(args)
+
+	key, cert := loadKeyAndCert(keyFileName, certFileName)
+
+	c := receipt.Constraints{
+		BundleID:        bundleID,
+		MinTransactions: minTransactions,
+		MaxTransactions: maxTransactions,
+	}
+
+	var err error
+	if c.Start, err = time.Parse(time.RFC3339, start); err != nil {
+		log.Fatal(err)
+	}
+	if c.End, err = time.Parse(time.RFC3339, end); err != nil {
+		log.Fatal(err)
+	}
+
+	if catalogFileName != "" {
+		c.Products = loadCatalog(catalogFileName).GeneratorProducts()
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	g := receipt.NewGenerator(seed, c)
+	for i := 1; i <= n; i++ {
+		rcpt := g.Receipt()
+
+		// The responses are requested when the receipts are created, so
+		// that the same seed results in the same files
+		creationDate := rcpt.ReceiptCreationDate.Time
+		now := func() time.Time { return creationDate }
+
+		var res receipt.Response
+		encodedReceipt, err := rcpt.Encode(key, cert, receipt.WithNow(now), receipt.WithResponse(&res))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		response, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		prefix := filepath.Join(outDir, fmt.Sprintf("%04d", i))
+		if err := ioutil.WriteFile(prefix+"-receipt.txt", []byte(encodedReceipt+"\n"), 0644); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(prefix+"-response.json", response, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/aktsk/kalvados/appstore"
+	"github.com/aktsk/kalvados/clock"
+	"github.com/aktsk/kalvados/receipt"
+)
+
+// refund refunds or revokes a transaction of JSON receipt data given
+// from stdin, and prints the encoded receipt
+func refund(args []string, revoke bool) {
+	var (
+		keyFileName          string
+		certFileName         string
+		now                  string
+		transactionID        string
+		reason               string
+		notificationFileName string
+	)
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&keyFileName, "keyFile", "key.pem", "Private Key file")
+	fs.StringVar(&certFileName, "certFile", "cert.pem", "Cetificate file")
+	fs.StringVar(&now, "now", "", "Current time in RFC 3339 format used as cancellation_date")
+	fs.StringVar(&transactionID, "transactionID", "", "transaction_id to cancel")
+	fs.StringVar(&reason, "reason", appstore.CancellationReasonOther, "cancellation_reason (0: other, 1: app issue)")
+	fs.StringVar(&notificationFileName, "notificationFile", "", "File to write the notification to")
+
+	fs.Parse(args)
+
+	key, cert := loadKeyAndCert(keyFileName, certFileName)
+
+	receiptJSON, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rcpt, err := receipt.UnmarshalReceipt(receiptJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nrcpt, err := rcpt.Nolmandy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := clock.New()
+	c.Freeze()
+	c.Set(nowFunc(now)())
+
+	store := appstore.New(c)
+	store.Record(nrcpt)
+
+	var n *appstore.Notification
+	if revoke {
+		n, err = store.Revoke(transactionID, reason)
+	} else {
+		n, err = store.Refund(transactionID, reason)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := store.Apply(nrcpt); err != nil {
+		log.Fatal(err)
+	}
+	rcpt.UpdateFromNolmandy(nrcpt)
+
+	encodedReceipt, err := rcpt.Encode(key, cert, receipt.WithNow(c.Now))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if notificationFileName != "" {
+		notification, err := json.MarshalIndent(n, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(notificationFileName, notification, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Println(encodedReceipt)
+}
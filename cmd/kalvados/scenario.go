@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/aktsk/kalvados/scenario"
+)
+
+// runScenario runs a scenario script, and writes the receipt, the
+// verifyReceipt response and the notifications of each step to a
+// directory
+func runScenario(args []string) {
+	if len(args) == 0 || args[0] != "run" {
+		log.Fatal("usage: kalvados scenario run [flags] script.yaml")
+	}
+
+	var (
+		keyFileName  string
+		certFileName string
+		outDir       string
+	)
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&keyFileName, "keyFile", "key.pem", "Private Key file")
+	fs.StringVar(&certFileName, "certFile", "cert.pem", "Cetificate file")
+	fs.StringVar(&outDir, "outDir", ".", "Directory to write the results to")
+
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		log.Fatal("usage: kalvados scenario run [flags] script.yaml")
+	}
+
+	key, cert := loadKeyAndCert(keyFileName, certFileName)
+
+	script, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := scenario.Parse(script)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results, err := scenario.Run(s, key, cert)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := scenario.WriteResults(outDir, results); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -4,4 +4,5 @@ require (
 	"github.com/aktsk/nolmandy" v0.1.0
 	"github.com/fullsailor/pkcs7" v0.0.0-20180223002317-1d5002593acb
 	"github.com/rakyll/statik" v0.1.1
+	"gopkg.in/yaml.v2" v2.4.0
 )
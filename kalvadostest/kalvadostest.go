@@ -0,0 +1,188 @@
+// Package kalvadostest provides an in-process kalvados server with a
+// signing identity and a mock App Store for tests.
+package kalvadostest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aktsk/kalvados/appstore"
+	"github.com/aktsk/kalvados/receipt"
+	"github.com/aktsk/kalvados/server"
+)
+
+// DefaultBundleID is the bundle ID of purchases made by Server
+const DefaultBundleID = "jp.aktsk.kalvados.test"
+
+// Server is a kalvados server listening on a local address. The
+// receipt encoder is at URL, and the mock verifyReceipt endpoint is at
+// VerifyURL.
+type Server struct {
+	*httptest.Server
+
+	Key  *rsa.PrivateKey
+	Cert *x509.Certificate
+
+	// BundleID is the bundle ID of purchases. It defaults to
+	// DefaultBundleID.
+	BundleID string
+
+	// Kalvados is the kalvados server, whose server-wide store is used
+	// by the helpers
+	Kalvados *server.Server
+}
+
+// NewServer starts a kalvados server with a new signing identity. It
+// is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	key, cert, err := GenerateKeyAndCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := server.New(key, cert)
+
+	s := &Server{
+		Server:   httptest.NewServer(k),
+		Key:      key,
+		Cert:     cert,
+		BundleID: DefaultBundleID,
+		Kalvados: k,
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// VerifyURL returns the URL of the mock verifyReceipt endpoint
+func (s *Server) VerifyURL() string {
+	return s.URL + "/verifyReceipt"
+}
+
+// Store returns the server-wide mock App Store
+func (s *Server) Store() *appstore.Store {
+	return s.Kalvados.Store("")
+}
+
+// AddProduct adds a product to the mock App Store
+func (s *Server) AddProduct(t testing.TB, p appstore.Product) {
+	t.Helper()
+
+	if err := s.Store().AddProduct(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Purchase is a purchase made by NewPurchase
+type Purchase struct {
+	Transaction *appstore.Transaction
+
+	// ReceiptData is the base64 encoded receipt which has all the
+	// transactions of the bundle ID
+	ReceiptData string
+}
+
+// NewPurchase purchases a product in the mock App Store. A product
+// which has not been added is added as a non-consumable product.
+func (s *Server) NewPurchase(t testing.TB, productID string, opts ...appstore.PurchaseOption) *Purchase {
+	t.Helper()
+
+	st := s.Store()
+
+	if _, ok := st.Catalog().Product(productID); !ok {
+		s.AddProduct(t, appstore.Product{ProductID: productID, Type: appstore.ProductTypeNonConsumable})
+	}
+
+	transaction, err := st.Purchase(s.BundleID, productID, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Purchase{Transaction: transaction, ReceiptData: s.ReceiptData(t)}
+}
+
+// ReceiptData returns the base64 encoded receipt which has all the
+// transactions of the bundle ID in the mock App Store
+func (s *Server) ReceiptData(t testing.TB) string {
+	t.Helper()
+
+	st := s.Store()
+
+	rcpt, err := st.Receipt(s.BundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiptData, err := receipt.EncodeReceipt(rcpt, s.Key, s.Cert, receipt.WithNow(st.Clock.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return receiptData
+}
+
+// Encode encodes JSON receipt data with the signing identity of the
+// server
+func (s *Server) Encode(t testing.TB, receiptJSON []byte) string {
+	t.Helper()
+
+	receiptData, err := receipt.Encode(receiptJSON, s.Key, s.Cert, receipt.WithNow(s.Store().Clock.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return receiptData
+}
+
+// Notifications returns the notifications sent by the mock App Store
+func (s *Server) Notifications() []*appstore.Notification {
+	return s.Store().Notifications()
+}
+
+// GenerateKeyAndCert generates a private key and a self-signed
+// certificate to sign receipts
+func GenerateKeyAndCert() (*rsa.PrivateKey, *x509.Certificate, error) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 32)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certTemplate := x509.Certificate{
+		SerialNumber:       serialNumber,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		Subject: pkix.Name{
+			CommonName:   "Kalvados Test Issuer",
+			Organization: []string{"Acme Co"},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().AddDate(10, 0, 0),
+		KeyUsage:  x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:      true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &certTemplate, &certTemplate, privKey.Public(), privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return privKey, cert, nil
+}
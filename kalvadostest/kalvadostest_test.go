@@ -0,0 +1,50 @@
+package kalvadostest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aktsk/kalvados/appstore"
+	"github.com/aktsk/kalvados/receipt"
+	nreceipt "github.com/aktsk/nolmandy/receipt"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(t)
+
+	s.AddProduct(t, appstore.Product{ProductID: "monthly", SubscriptionPeriod: "P1M"})
+
+	purchase := s.NewPurchase(t, "monthly")
+	coins := s.NewPurchase(t, "coins")
+
+	rcpt, err := nreceipt.Parse(s.Cert, coins.ReceiptData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rcpt.InApp) != 2 || rcpt.BundleID != DefaultBundleID {
+		t.Fatalf("Wrong receipt: %+v", rcpt)
+	}
+
+	body, _ := json.Marshal(map[string]string{"receipt-data": purchase.ReceiptData})
+	resp, err := http.Post(s.VerifyURL(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res receipt.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != 0 || len(res.LatestReceiptInfo) != 2 || len(res.PendingRenewalInfo) != 1 {
+		t.Fatalf("Wrong response: %+v", res)
+	}
+
+	notifications := s.Notifications()
+	if len(notifications) != 1 || notifications[0].OriginalTransactionID != purchase.Transaction.TransactionID {
+		t.Fatalf("Wrong notifications: %+v", notifications)
+	}
+}
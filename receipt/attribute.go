@@ -0,0 +1,187 @@
+package receipt
+
+import (
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidAttribute is returned when an attribute has more than one
+// value
+var ErrInvalidAttribute = errors.New("attribute must have at most one of raw, integer, utf8_string and ia5_string")
+
+// Attribute is an ASN.1 attribute of a receipt. Its value is given as
+// one of raw DER in base64, an INTEGER, a UTF8String or an IA5String.
+type Attribute struct {
+	Type       int     `json:"type"`
+	Version    *int    `json:"version,omitempty"`
+	Raw        []byte  `json:"raw,omitempty"`
+	Integer    *int64  `json:"integer,omitempty"`
+	UTF8String *string `json:"utf8_string,omitempty"`
+	IA5String  *string `json:"ia5_string,omitempty"`
+
+	// Remove removes the attributes of the type. It is only for
+	// overrides.
+	Remove bool `json:"remove,omitempty"`
+}
+
+// Attributes changes the attributes of a receipt or an in-app purchase
+// receipt from the ones the encoder generates, to test the robustness of
+// receipt parsers.
+//
+// Extra attributes are added, which can be of unknown types or
+// duplicates of known ones. Overrides change the version or the value of
+// all the attributes of a type, or remove them. Types in Order are
+// encoded first in the order, and the others follow.
+type Attributes struct {
+	Extra     []Attribute `json:"extra_attributes,omitempty"`
+	Overrides []Attribute `json:"attribute_overrides,omitempty"`
+	Order     []int       `json:"attribute_order,omitempty"`
+}
+
+// ReceiptAttributes changes the attributes of a receipt and its in-app
+// purchase receipts
+type ReceiptAttributes struct {
+	Attributes
+	InApp []Attributes `json:"in_app,omitempty"`
+}
+
+// WithAttributes changes the attributes of the encoded receipt
+func WithAttributes(a *ReceiptAttributes) Option {
+	return func(o *options) {
+		o.attributes = a
+	}
+}
+
+// UnmarshalAttributes parses extra_attributes, attribute_overrides and
+// attribute_order of JSON receipt data and its in_app
+func UnmarshalAttributes(receiptJSON []byte) (*ReceiptAttributes, error) {
+	a := ReceiptAttributes{}
+	if err := json.Unmarshal(receiptJSON, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// inApp returns the attributes of the i-th in-app purchase receipt
+func (a *ReceiptAttributes) inApp(i int) *Attributes {
+	if a == nil || i >= len(a.InApp) {
+		return nil
+	}
+	return &a.InApp[i]
+}
+
+func (a *Attribute) value() ([]byte, bool, error) {
+	values := 0
+	var value []byte
+	var err error
+
+	if a.Raw != nil {
+		values++
+		value = a.Raw
+	}
+	if a.Integer != nil {
+		values++
+		value, err = asn1.Marshal(*a.Integer)
+	}
+	if a.UTF8String != nil {
+		values++
+		value, err = asn1.MarshalWithParams(*a.UTF8String, "utf8")
+	}
+	if a.IA5String != nil {
+		values++
+		value, err = asn1.MarshalWithParams(*a.IA5String, "ia5")
+	}
+
+	if values > 1 {
+		return nil, false, fmt.Errorf("%d: %v", a.Type, ErrInvalidAttribute)
+	}
+
+	return value, values == 1, err
+}
+
+// hasExtra returns whether an extra attribute of a type is added
+func (a *Attributes) hasExtra(typ int) bool {
+	if a == nil {
+		return false
+	}
+	for _, extra := range a.Extra {
+		if extra.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// apply changes the attributes generated by the encoder
+func (a *Attributes) apply(payload []attribute) ([]attribute, error) {
+	if a == nil {
+		return payload, nil
+	}
+
+	for _, o := range a.Overrides {
+		value, ok, err := o.value()
+		if err != nil {
+			return nil, err
+		}
+
+		overridden := []attribute{}
+		for _, ra := range payload {
+			if ra.Type == o.Type {
+				if o.Remove {
+					continue
+				}
+				if o.Version != nil {
+					ra.Version = *o.Version
+				}
+				if ok {
+					ra.Value = value
+				}
+			}
+			overridden = append(overridden, ra)
+		}
+		payload = overridden
+	}
+
+	for _, extra := range a.Extra {
+		value, _, err := extra.value()
+		if err != nil {
+			return nil, err
+		}
+
+		ra := attribute{Type: extra.Type, Value: value}
+		if extra.Version != nil {
+			ra.Version = *extra.Version
+		}
+		payload = append(payload, ra)
+	}
+
+	if len(a.Order) == 0 {
+		return payload, nil
+	}
+
+	ordered := []attribute{}
+	used := make([]bool, len(payload))
+	for _, typ := range a.Order {
+		for i, ra := range payload {
+			if !used[i] && ra.Type == typ {
+				ordered = append(ordered, ra)
+				used[i] = true
+			}
+		}
+	}
+	for i, ra := range payload {
+		if !used[i] {
+			ordered = append(ordered, ra)
+		}
+	}
+
+	return ordered, nil
+}
+
+// ordered reports whether the attributes must be encoded in their order
+func (a *Attributes) ordered() bool {
+	return a != nil && len(a.Order) > 0
+}
@@ -0,0 +1,66 @@
+package receipt
+
+import (
+	"encoding/asn1"
+)
+
+// indefiniteLength re-encodes a DER encoded PKCS#7 SignedData in BER
+// like receipts of the App Store. The containers from the ContentInfo
+// down to the content are encoded with indefinite lengths, and the
+// content is wrapped in a constructed OCTET STRING. Certificates and
+// signer infos are left in DER.
+func indefiniteLength(der []byte) ([]byte, error) {
+	var contentInfo asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &contentInfo); err != nil {
+		return nil, err
+	}
+
+	// ContentInfo, [0] content, SignedData, EncapsulatedContentInfo,
+	// [0] eContent and then the OCTET STRING
+	encode := indefiniteAt(1,
+		indefiniteAt(0,
+			indefiniteAt(2,
+				indefiniteAt(1,
+					indefiniteAt(0, constructedOctetString)))))
+
+	return encode(contentInfo)
+}
+
+// indefiniteAt returns a function which encodes a constructed value with
+// an indefinite length. Its i-th element is re-encoded by f, and the
+// others are kept as they are.
+func indefiniteAt(i int, f func(asn1.RawValue) ([]byte, error)) func(asn1.RawValue) ([]byte, error) {
+	return func(v asn1.RawValue) ([]byte, error) {
+		b := []byte{v.FullBytes[0], 0x80}
+
+		rest := v.Bytes
+		for n := 0; len(rest) > 0; n++ {
+			var e asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &e); err != nil {
+				return nil, err
+			}
+
+			if n != i {
+				b = append(b, e.FullBytes...)
+				continue
+			}
+
+			encoded, err := f(e)
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, encoded...)
+		}
+
+		return append(b, 0, 0), nil
+	}
+}
+
+// constructedOctetString wraps a primitive OCTET STRING in a
+// constructed one with an indefinite length
+func constructedOctetString(v asn1.RawValue) ([]byte, error) {
+	b := []byte{0x20 | asn1.TagOctetString, 0x80}
+	b = append(b, v.FullBytes...)
+	return append(b, 0, 0), nil
+}
@@ -0,0 +1,173 @@
+package receipt
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+)
+
+// Decode decodes base64 encoded receipt data without verifying its
+// signature, so receipts signed by anyone, including the App Store, can
+// be decoded. Attributes the receipt does not have fields for are
+// returned as extra attributes, so that they are kept when the receipt
+// is encoded again with WithAttributes.
+func Decode(receiptData string) (*Receipt, *ReceiptAttributes, error) {
+	data, err := base64.StdEncoding.DecodeString(receiptData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return decodePayload(p7.Content)
+}
+
+func decodePayload(payload []byte) (*Receipt, *ReceiptAttributes, error) {
+	rcpt := &Receipt{Version: ModelVersion, InApp: []*InApp{}}
+	a := &ReceiptAttributes{}
+
+	attributes, err := decodeAttributes(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, ra := range attributes {
+		switch ra.Type {
+		case 0:
+			_, err = asn1.Unmarshal(ra.Value, &rcpt.ReceiptType)
+		case 2:
+			_, err = asn1.Unmarshal(ra.Value, &rcpt.BundleID)
+		case 3:
+			_, err = asn1.Unmarshal(ra.Value, &rcpt.ApplicationVersion)
+		case 4:
+			rcpt.OpaqueValue = ra.Value
+		case 5:
+			rcpt.SHA1Hash = ra.Value
+		case 12:
+			err = decodeDate(ra.Value, &rcpt.ReceiptCreationDate)
+		case 17:
+			var inApp *InApp
+			var inAppAttributes *Attributes
+			inApp, inAppAttributes, err = decodeInApp(ra.Value)
+			if err == nil {
+				rcpt.InApp = append(rcpt.InApp, inApp)
+				a.InApp = append(a.InApp, *inAppAttributes)
+			}
+		case 18:
+			err = decodeDate(ra.Value, &rcpt.OriginalPurchaseDate)
+		case 19:
+			_, err = asn1.Unmarshal(ra.Value, &rcpt.OriginalApplicationVersion)
+		case 21:
+			err = decodeDate(ra.Value, &rcpt.ExpirationDate)
+		default:
+			a.Extra = append(a.Extra, extraAttribute(ra))
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return rcpt, a, nil
+}
+
+func decodeInApp(data []byte) (*InApp, *Attributes, error) {
+	inApp := &InApp{IsTrialPeriod: "false"}
+	a := &Attributes{}
+
+	attributes, err := decodeAttributes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, ra := range attributes {
+		switch ra.Type {
+		case 1701:
+			_, err = asn1.Unmarshal(ra.Value, &inApp.Quantity)
+		case 1702:
+			_, err = asn1.Unmarshal(ra.Value, &inApp.ProductID)
+		case 1703:
+			_, err = asn1.Unmarshal(ra.Value, &inApp.TransactionID)
+		case 1704:
+			err = decodeDate(ra.Value, &inApp.PurchaseDate)
+		case 1705:
+			_, err = asn1.Unmarshal(ra.Value, &inApp.OriginalTransactionID)
+		case 1706:
+			err = decodeDate(ra.Value, &inApp.OriginalPurchaseDate)
+		case 1708:
+			err = decodeDate(ra.Value, &inApp.ExpiresDate)
+		case 1711:
+			_, err = asn1.Unmarshal(ra.Value, &inApp.WebOrderLineItemID)
+		case 1712:
+			err = decodeDate(ra.Value, &inApp.CancellationDate)
+		case 1719:
+			var introPrice int
+			_, err = asn1.Unmarshal(ra.Value, &introPrice)
+			inApp.IsInIntroOfferPeriod = strconv.FormatBool(introPrice != 0)
+		default:
+			a.Extra = append(a.Extra, extraAttribute(ra))
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return inApp, a, nil
+}
+
+// decodeAttributes decodes a SET or a SEQUENCE of attributes
+func decodeAttributes(data []byte) ([]attribute, error) {
+	var container asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &container); err != nil {
+		return nil, err
+	}
+
+	attributes := []attribute{}
+	rest := container.Bytes
+	for len(rest) > 0 {
+		var ra attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &ra); err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, ra)
+	}
+
+	return attributes, nil
+}
+
+// decodeDate decodes an RFC 3339 date. Empty dates are left as zero.
+func decodeDate(data []byte, d *Date) error {
+	var s string
+	if _, err := asn1.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+
+	d.Time = t.UTC()
+	return nil
+}
+
+func extraAttribute(ra attribute) Attribute {
+	a := Attribute{Type: ra.Type, Raw: ra.Value}
+	if ra.Version != 0 {
+		version := ra.Version
+		a.Version = &version
+	}
+	return a
+}
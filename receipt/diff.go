@@ -0,0 +1,263 @@
+package receipt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kinds of changes
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Change is a difference between two receipts. Path is like
+// "bundle_id", "in_app[220000350729970].product_id" or
+// "signers[0].signature". A and B are the values in each receipt.
+type Change struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+	A    string `json:"a,omitempty"`
+	B    string `json:"b,omitempty"`
+}
+
+// Diff decodes two base64 encoded receipt data and returns their
+// differences. In-app purchase receipts are matched by transaction_id.
+func Diff(a, b string) ([]*Change, error) {
+	ra, err := Inspect(a)
+	if err != nil {
+		return nil, err
+	}
+
+	rb, err := Inspect(b)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &differ{changes: []*Change{}}
+
+	d.value("indefinite_length", strconv.FormatBool(ra.IndefiniteLength), strconv.FormatBool(rb.IndefiniteLength))
+	d.value("payload", ra.Payload, rb.Payload)
+
+	for i := 0; i < len(ra.Certificates) || i < len(rb.Certificates); i++ {
+		var fa, fb map[string]string
+		if i < len(ra.Certificates) {
+			fa = certificateFields(ra.Certificates[i])
+		}
+		if i < len(rb.Certificates) {
+			fb = certificateFields(rb.Certificates[i])
+		}
+		d.fields(fmt.Sprintf("certificates[%d]", i), fa, fb)
+	}
+
+	for i := 0; i < len(ra.Signers) || i < len(rb.Signers); i++ {
+		var fa, fb map[string]string
+		if i < len(ra.Signers) {
+			fa = signerFields(ra.Signers[i])
+		}
+		if i < len(rb.Signers) {
+			fb = signerFields(rb.Signers[i])
+		}
+		d.fields(fmt.Sprintf("signers[%d]", i), fa, fb)
+	}
+
+	inAppsA, attributesA := splitInApp(ra.Attributes)
+	inAppsB, attributesB := splitInApp(rb.Attributes)
+
+	d.fields("", attributeFields(attributesA), attributeFields(attributesB))
+
+	keys := []string{}
+	for k := range inAppsA {
+		keys = append(keys, k)
+	}
+	for k := range inAppsB {
+		if _, ok := inAppsA[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var fa, fb map[string]string
+		if inApp, ok := inAppsA[k]; ok {
+			fa = attributeFields(inApp)
+		}
+		if inApp, ok := inAppsB[k]; ok {
+			fb = attributeFields(inApp)
+		}
+		d.fields(fmt.Sprintf("in_app[%s]", k), fa, fb)
+	}
+
+	return d.changes, nil
+}
+
+// WriteChanges writes changes in a line each, prefixed by +, - or ~
+func WriteChanges(w io.Writer, changes []*Change) {
+	for _, c := range changes {
+		switch c.Kind {
+		case ChangeAdded:
+			fmt.Fprintf(w, "+ %s: %s\n", c.Path, c.B)
+		case ChangeRemoved:
+			fmt.Fprintf(w, "- %s: %s\n", c.Path, c.A)
+		case ChangeChanged:
+			fmt.Fprintf(w, "~ %s: %s -> %s\n", c.Path, c.A, c.B)
+		}
+	}
+}
+
+type differ struct {
+	changes []*Change
+}
+
+func (d *differ) value(path, a, b string) {
+	if a != b {
+		d.changes = append(d.changes, &Change{Kind: ChangeChanged, Path: path, A: a, B: b})
+	}
+}
+
+// fields compares fields of something at path. A missing something is
+// nil, and reported as a whole.
+func (d *differ) fields(path string, a, b map[string]string) {
+	switch {
+	case a == nil && b == nil:
+		return
+	case a == nil:
+		d.changes = append(d.changes, &Change{Kind: ChangeAdded, Path: path, B: summary(b)})
+		return
+	case b == nil:
+		d.changes = append(d.changes, &Change{Kind: ChangeRemoved, Path: path, A: summary(a)})
+		return
+	}
+
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+
+		va, okA := a[k]
+		vb, okB := b[k]
+		switch {
+		case !okA:
+			d.changes = append(d.changes, &Change{Kind: ChangeAdded, Path: p, B: vb})
+		case !okB:
+			d.changes = append(d.changes, &Change{Kind: ChangeRemoved, Path: p, A: va})
+		default:
+			d.value(p, va, vb)
+		}
+	}
+}
+
+// summary describes something added or removed as a whole
+func summary(fields map[string]string) string {
+	for _, k := range []string{"product_id", "subject", "serial_number"} {
+		if v, ok := fields[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func certificateFields(c *CertificateReport) map[string]string {
+	return map[string]string{
+		"subject":             c.Subject,
+		"issuer":              c.Issuer,
+		"serial_number":       c.SerialNumber,
+		"not_before":          c.NotBefore.Format(time.RFC3339),
+		"not_after":           c.NotAfter.Format(time.RFC3339),
+		"signature_algorithm": c.SignatureAlgorithm,
+	}
+}
+
+func signerFields(s *SignerReport) map[string]string {
+	fields := map[string]string{
+		"issuer":              s.Issuer,
+		"serial_number":       s.SerialNumber,
+		"digest_algorithm":    s.DigestAlgorithm,
+		"signature_algorithm": s.SignatureAlgorithm,
+		"signature":           s.Signature,
+	}
+	if s.SigningTime != nil {
+		fields["signing_time"] = s.SigningTime.Format(time.RFC3339)
+	}
+	return fields
+}
+
+// splitInApp splits the in-app purchase receipts keyed by transaction_id
+// from the other attributes
+func splitInApp(attributes []*AttributeReport) (map[string][]*AttributeReport, []*AttributeReport) {
+	inApps := map[string][]*AttributeReport{}
+	others := []*AttributeReport{}
+
+	for _, a := range attributes {
+		if a.Type != 17 || a.InApp == nil {
+			others = append(others, a)
+			continue
+		}
+
+		key := ""
+		for _, ia := range a.InApp {
+			if ia.Type == 1703 {
+				key = fmt.Sprint(ia.Value)
+			}
+		}
+
+		// in_app without transaction_id, or with the same one, are
+		// keyed by the order
+		if _, ok := inApps[key]; ok || key == "" {
+			key = fmt.Sprintf("%s#%d", key, len(inApps))
+		}
+
+		inApps[key] = a.InApp
+	}
+
+	return inApps, others
+}
+
+// attributeFields returns the values of attributes keyed by their names.
+// Attributes of the same type are numbered.
+func attributeFields(attributes []*AttributeReport) map[string]string {
+	fields := map[string]string{}
+	counts := map[int]int{}
+
+	for _, a := range attributes {
+		key := a.Name
+		if key == "" {
+			key = strconv.Itoa(a.Type)
+		}
+		if counts[a.Type] > 0 {
+			key = fmt.Sprintf("%s#%d", key, counts[a.Type])
+		}
+		counts[a.Type]++
+
+		value := a.Raw
+		switch v := a.Value.(type) {
+		case string:
+			value = strconv.Quote(v)
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		}
+		if a.Version != 0 {
+			value = fmt.Sprintf("%s (version %d)", value, a.Version)
+		}
+
+		fields[key] = fmt.Sprintf("%s %s", a.Tag, value)
+	}
+
+	return fields
+}
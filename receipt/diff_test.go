@@ -0,0 +1,79 @@
+package receipt
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+	otherKey, otherCert := generateKeyAndCert()
+
+	a, err := Encode([]byte(receiptJSON), privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("The same receipts should not have changes: %+v", changes)
+	}
+
+	var r map[string]interface{}
+	if err := json.Unmarshal([]byte(receiptJSON), &r); err != nil {
+		t.Fatal(err)
+	}
+	r["bundle_id"] = "jp.aktsk.kalvados.changed"
+	inApps := r["in_app"].([]interface{})
+	inApps[1].(map[string]interface{})["product_id"] = "jp.aktsk.kalvados.test.changed"
+	inApps[2].(map[string]interface{})["transaction_id"] = "220000400000000"
+	r["in_app"] = inApps[1:]
+
+	changedJSON, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Encode(changedJSON, otherKey, otherCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"bundle_id":                              ChangeChanged,
+		"in_app[220000359893979].product_id":     ChangeChanged,
+		"in_app[220000350729970]":                ChangeRemoved,
+		"in_app[220000368932558]":                ChangeRemoved,
+		"in_app[220000400000000]":                ChangeAdded,
+		"certificates[0].serial_number":          ChangeChanged,
+		"signers[0].signature":                   ChangeChanged,
+		"in_app[220000359893979].transaction_id": "",
+	}
+
+	found := map[string]string{}
+	for _, c := range changes {
+		found[c.Path] = c.Kind
+	}
+
+	for path, kind := range expected {
+		if found[path] != kind {
+			t.Errorf("%s should be %q but %q", path, kind, found[path])
+		}
+	}
+
+	var w bytes.Buffer
+	WriteChanges(&w, changes)
+	if !strings.Contains(w.String(), `~ bundle_id: UTF8String "jp.aktsk.kalvados.test" -> UTF8String "jp.aktsk.kalvados.changed"`) {
+		t.Fatalf("Wrong changes:\n%s", w.String())
+	}
+}
@@ -0,0 +1,248 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPatch is returned when a patch cannot be applied
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// Patch is a JSON Patch (RFC 6902) operation on JSON receipt data. The
+// operations are add, remove and replace.
+type Patch struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Marshal returns JSON receipt data of a receipt and its attributes,
+// which UnmarshalReceipt and UnmarshalAttributes read. Empty dates are
+// omitted.
+func Marshal(r *Receipt, a *ReceiptAttributes) ([]byte, error) {
+	doc, err := toJSONValue(r)
+	if err != nil {
+		return nil, err
+	}
+
+	receiptMap := doc.(map[string]interface{})
+	removeEmptyDates(receiptMap, receiptDateFields)
+
+	inApps, _ := receiptMap["in_app"].([]interface{})
+	for i, inApp := range inApps {
+		inAppMap := inApp.(map[string]interface{})
+		removeEmptyDates(inAppMap, inAppDateFields)
+
+		if a != nil && i < len(a.InApp) {
+			if err := mergeJSON(inAppMap, a.InApp[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// opaque_value and sha1_hash are fields of the receipt, so only the
+	// attributes the receipt does not have fields for are extra ones
+	if a != nil {
+		if err := mergeJSON(receiptMap, a.Attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.MarshalIndent(receiptMap, "", "  ")
+}
+
+// removeEmptyDates removes the dates which are not set
+func removeEmptyDates(m map[string]interface{}, fields []string) {
+	for _, field := range fields {
+		if m[field] == "" {
+			delete(m, field)
+		}
+	}
+}
+
+// toJSONValue converts v into maps and slices through JSON. Numbers are
+// kept as json.Number not to lose precision of IDs.
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var value interface{}
+	if err := d.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func mergeJSON(m map[string]interface{}, v interface{}) error {
+	value, err := toJSONValue(v)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range value.(map[string]interface{}) {
+		m[k] = v
+	}
+
+	return nil
+}
+
+// ApplyPatches applies patches to JSON receipt data
+func ApplyPatches(receiptJSON []byte, patches []Patch) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(receiptJSON))
+	d.UseNumber()
+
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	for _, p := range patches {
+		var err error
+		if doc, err = applyPatch(doc, p); err != nil {
+			return nil, fmt.Errorf("%s %s: %v", p.Op, p.Path, err)
+		}
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func applyPatch(doc interface{}, p Patch) (interface{}, error) {
+	if p.Op != "add" && p.Op != "remove" && p.Op != "replace" {
+		return nil, ErrInvalidPatch
+	}
+
+	if !strings.HasPrefix(p.Path, "/") {
+		return nil, ErrInvalidPatch
+	}
+
+	var value interface{}
+	if p.Op != "remove" {
+		d := json.NewDecoder(bytes.NewReader(p.Value))
+		d.UseNumber()
+		if err := d.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+
+	tokens := strings.Split(p.Path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return patchValue(doc, tokens, p.Op, value)
+}
+
+// patchValue applies an operation at the path given by tokens in doc,
+// and returns the patched doc
+func patchValue(doc interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	token := tokens[0]
+	last := len(tokens) == 1
+
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[token]
+		if !last {
+			if !ok {
+				return nil, ErrInvalidPatch
+			}
+			patched, err := patchValue(child, tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			d[token] = patched
+			return d, nil
+		}
+
+		switch op {
+		case "add":
+			d[token] = value
+		case "replace":
+			if !ok {
+				return nil, ErrInvalidPatch
+			}
+			d[token] = value
+		case "remove":
+			if !ok {
+				return nil, ErrInvalidPatch
+			}
+			delete(d, token)
+		}
+		return d, nil
+
+	case []interface{}:
+		if last && op == "add" && token == "-" {
+			return append(d, value), nil
+		}
+
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > len(d) || (i == len(d) && !(last && op == "add")) {
+			return nil, ErrInvalidPatch
+		}
+
+		if !last {
+			patched, err := patchValue(d[i], tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			d[i] = patched
+			return d, nil
+		}
+
+		switch op {
+		case "add":
+			d = append(d, nil)
+			copy(d[i+1:], d[i:])
+			d[i] = value
+		case "replace":
+			d[i] = value
+		case "remove":
+			d = append(d[:i], d[i+1:]...)
+		}
+		return d, nil
+	}
+
+	return nil, ErrInvalidPatch
+}
+
+// Resign decodes base64 encoded receipt data as Decode does, applies
+// patches to its JSON receipt data, and signs it with key and cert.
+func Resign(receiptData string, key *rsa.PrivateKey, cert *x509.Certificate, patches []Patch, opts ...Option) (string, error) {
+	rcpt, attributes, err := Decode(receiptData)
+	if err != nil {
+		return "", err
+	}
+
+	if len(patches) > 0 {
+		receiptJSON, err := Marshal(rcpt, attributes)
+		if err != nil {
+			return "", err
+		}
+
+		if receiptJSON, err = ApplyPatches(receiptJSON, patches); err != nil {
+			return "", err
+		}
+
+		if rcpt, err = UnmarshalReceipt(receiptJSON); err != nil {
+			return "", err
+		}
+
+		if attributes, err = UnmarshalAttributes(receiptJSON); err != nil {
+			return "", err
+		}
+	}
+
+	opts = append([]Option{WithAttributes(attributes)}, opts...)
+	return rcpt.Encode(key, cert, opts...)
+}
@@ -0,0 +1,204 @@
+package receipt
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+func TestResign(t *testing.T) {
+	originalKey, originalCert := generateKeyAndCert()
+	privKey, cert := generateKeyAndCert()
+
+	original, err := Encode([]byte(receiptJSON), originalKey, originalCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// in_app are in the order of the encoded SET
+	decoded, _, err := Decode(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := map[string]int{}
+	for i, inApp := range decoded.InApp {
+		index[inApp.TransactionID] = i
+	}
+
+	removed := index["220000350729970"]
+	edited := index["220000359893979"]
+	if edited > removed {
+		edited--
+	}
+
+	patches := []Patch{}
+	err = json.Unmarshal([]byte(fmt.Sprintf(`[
+  {"op": "replace", "path": "/bundle_id", "value": "jp.aktsk.kalvados.edited"},
+  {"op": "remove", "path": "/in_app/%d"},
+  {"op": "replace", "path": "/in_app/%d/product_id", "value": "jp.aktsk.kalvados.test.edited"},
+  {"op": "add", "path": "/in_app/-", "value": {
+    "quantity": "1",
+    "product_id": "jp.aktsk.kalvados.test.monthly",
+    "transaction_id": "220000400000000",
+    "original_transaction_id": "220000400000000",
+    "purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+    "original_purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+    "expires_date": "2018-02-01 00:00:00 Etc/GMT"
+  }}
+]`, removed, edited)), &patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt, err := Resign(original, privKey, cert, patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := receipt.Parse(originalCert, rcpt); err == nil {
+		t.Fatal("Receipt should not be signed by the original key")
+	}
+
+	parsed, err := receipt.Parse(cert, rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.BundleID != "jp.aktsk.kalvados.edited" {
+		t.Fatalf("Wrong bundle_id: %s", parsed.BundleID)
+	}
+
+	inApps := map[string]*receipt.InApp{}
+	for _, inApp := range parsed.InApp {
+		inApps[inApp.TransactionID] = inApp
+	}
+
+	if len(inApps) != 3 || inApps["220000350729970"] != nil {
+		t.Fatalf("Wrong in_app: %d", len(parsed.InApp))
+	}
+
+	if inApps["220000359893979"].ProductID != "jp.aktsk.kalvados.test.edited" {
+		t.Fatalf("Wrong product_id: %s", inApps["220000359893979"].ProductID)
+	}
+
+	if inApps["220000368932558"].WebOrderLineItemID != 220000075821143 {
+		t.Fatalf("Wrong web_order_line_item_id: %d", inApps["220000368932558"].WebOrderLineItemID)
+	}
+
+	expiresDate := time.Time(inApps["220000400000000"].ExpiresDate.Date)
+	if !expiresDate.Equal(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong expires_date: %v", expiresDate)
+	}
+
+	invalid := []Patch{{Op: "replace", Path: "/in_app/9/product_id", Value: json.RawMessage(`"x"`)}}
+	if _, err := Resign(original, privKey, cert, invalid); err == nil {
+		t.Fatal("Patch of missing in_app should be an error")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	rcpt := &Receipt{
+		Version:             ModelVersion,
+		BundleID:            "jp.aktsk.kalvados.test",
+		ReceiptCreationDate: Date{time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)},
+		OpaqueValue:         []byte("opaque"),
+		InApp: []*InApp{
+			{
+				Quantity:             1,
+				ProductID:            "jp.aktsk.kalvados.test.premium",
+				TransactionID:        "1000000000000001",
+				PurchaseDate:         Date{time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)},
+				IsTrialPeriod:        "false",
+				IsInIntroOfferPeriod: "true",
+				InAppOwnershipType:   "FAMILY_SHARED",
+			},
+		},
+	}
+
+	receiptJSON, err := Marshal(rcpt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(receiptJSON, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m["expiration_date"]; ok {
+		t.Fatalf("Empty dates should be omitted: %s", receiptJSON)
+	}
+
+	if _, ok := m["extra_attributes"]; ok {
+		t.Fatalf("opaque_value should not be an extra attribute: %s", receiptJSON)
+	}
+
+	unmarshalled, err := UnmarshalReceipt(receiptJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(unmarshalled, rcpt) {
+		t.Fatalf("Receipt should be marshalled as it is:\n%+v\n%+v", unmarshalled.InApp[0], rcpt.InApp[0])
+	}
+}
+
+func TestDecodeKeepsUnknownAttributes(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	var r map[string]interface{}
+	if err := json.Unmarshal([]byte(receiptJSON), &r); err != nil {
+		t.Fatal(err)
+	}
+	r["extra_attributes"] = []interface{}{
+		map[string]interface{}{"type": 4, "raw": "b3BhcXVl"},
+		map[string]interface{}{"type": 1234, "version": 1, "utf8_string": "unknown"},
+	}
+	r["in_app"].([]interface{})[2].(map[string]interface{})["extra_attributes"] = []interface{}{
+		map[string]interface{}{"type": 1707, "integer": 1},
+	}
+
+	receiptJSON, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := Encode(receiptJSON, privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patches := []Patch{{Op: "replace", Path: "/original_application_version", Value: json.RawMessage(`"50"`)}}
+	rcpt, err := Resign(original, privKey, cert, patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, attributes, err := Decode(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.OriginalApplicationVersion != "50" {
+		t.Fatalf("Wrong original_application_version: %s", decoded.OriginalApplicationVersion)
+	}
+
+	if string(decoded.OpaqueValue) != "opaque" {
+		t.Fatalf("opaque_value should be kept: %q", decoded.OpaqueValue)
+	}
+
+	if len(attributes.Extra) != 1 || attributes.Extra[0].Type != 1234 || *attributes.Extra[0].Version != 1 {
+		t.Fatalf("Unknown attribute should be kept: %+v", attributes.Extra)
+	}
+
+	for i, inApp := range decoded.InApp {
+		extra := attributes.InApp[i].Extra
+		if inApp.TransactionID == "220000368932558" && (len(extra) != 1 || extra[0].Type != 1707) {
+			t.Fatalf("Unknown in-app attribute should be kept: %+v", extra)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/fullsailor/pkcs7"
+)
+
+// Mutations of receipts for fuzzing
+const (
+	// MutationBitFlip flips bits of the payload
+	MutationBitFlip = "bit_flip"
+	// MutationLength corrupts a length of an ASN.1 value in the payload
+	MutationLength = "length"
+	// MutationSwap swaps the values or the positions of two attributes
+	MutationSwap = "swap"
+	// MutationSignature corrupts the signature
+	MutationSignature = "signature"
+)
+
+// Mutations are all the mutations of receipts
+var Mutations = []string{MutationBitFlip, MutationLength, MutationSwap, MutationSignature}
+
+// ErrUnknownMutation is returned when a mutation is not known
+var ErrUnknownMutation = errors.New("unknown mutation")
+
+// FuzzConfig configures how receipts are mutated
+type FuzzConfig struct {
+	// Seed is the seed of the random mutations. The same seed results in
+	// the same mutants.
+	Seed int64
+	// N is the number of mutants
+	N int
+	// Mutations are the mutations to choose from. All the mutations are
+	// chosen from when it is empty.
+	Mutations []string
+	// Key and Cert re-sign mutated payloads when they are given.
+	// Otherwise mutated payloads are put into the original container, so
+	// their signatures do not match.
+	Key  *rsa.PrivateKey
+	Cert *x509.Certificate
+}
+
+// Mutant is a mutated receipt
+type Mutant struct {
+	ReceiptData string `json:"receipt-data"`
+	Mutation    string `json:"mutation"`
+}
+
+// Fuzz returns mutants of base64 encoded receipt data
+func Fuzz(receiptData string, c FuzzConfig) ([]*Mutant, error) {
+	mutations := c.Mutations
+	if len(mutations) == 0 {
+		mutations = Mutations
+	}
+
+	for _, m := range mutations {
+		if !isMutation(m) {
+			return nil, fmt.Errorf("%s: %v", m, ErrUnknownMutation)
+		}
+	}
+
+	container, err := base64.StdEncoding.DecodeString(receiptData)
+	if err != nil {
+		return nil, err
+	}
+
+	p7, err := pkcs7.Parse(container)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadOffset := bytes.Index(container, p7.Content)
+	if payloadOffset < 0 || len(p7.Signers) == 0 {
+		return nil, errors.New("payload or signature is not found")
+	}
+
+	signatureOffset := bytes.LastIndex(container, p7.Signers[0].EncryptedDigest)
+	if signatureOffset < 0 {
+		return nil, errors.New("signature is not found")
+	}
+
+	rng := rand.New(rand.NewSource(c.Seed))
+
+	mutants := []*Mutant{}
+	for i := 0; i < c.N; i++ {
+		m := mutations[rng.Intn(len(mutations))]
+
+		mutated := append([]byte{}, container...)
+
+		if m == MutationSignature {
+			flipBits(rng, mutated[signatureOffset:signatureOffset+len(p7.Signers[0].EncryptedDigest)])
+		} else {
+			payload := append([]byte{}, p7.Content...)
+			switch m {
+			case MutationBitFlip:
+				flipBits(rng, payload)
+			case MutationLength:
+				corruptLength(rng, payload)
+			case MutationSwap:
+				swapAttributes(rng, payload)
+			}
+
+			if c.Key != nil && c.Cert != nil {
+				if mutated, err = signReceipt(payload, c.Key, c.Cert); err != nil {
+					return nil, err
+				}
+			} else {
+				copy(mutated[payloadOffset:], payload)
+			}
+		}
+
+		mutants = append(mutants, &Mutant{
+			ReceiptData: base64.StdEncoding.EncodeToString(mutated),
+			Mutation:    m,
+		})
+	}
+
+	return mutants, nil
+}
+
+func isMutation(m string) bool {
+	for _, mutation := range Mutations {
+		if m == mutation {
+			return true
+		}
+	}
+	return false
+}
+
+// flipBits flips one to eight distinct bits of b
+func flipBits(rng *rand.Rand, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	n := 1 + rng.Intn(8)
+	flipped := map[int]bool{}
+	for len(flipped) < n && len(flipped) < len(b)*8 {
+		bit := rng.Intn(len(b) * 8)
+		if flipped[bit] {
+			continue
+		}
+		flipped[bit] = true
+		b[bit/8] ^= 1 << uint(bit%8)
+	}
+}
+
+// tlv is an ASN.1 value found in DER
+type tlv struct {
+	offset        int
+	lengthOffset  int
+	contentOffset int
+	end           int
+}
+
+func (v tlv) tag(b []byte) byte {
+	return b[v.offset]
+}
+
+// children returns the ASN.1 values in b[start:end]
+func children(b []byte, start, end int) ([]tlv, bool) {
+	values := []tlv{}
+	for offset := start; offset < end; {
+		if offset+2 > end || b[offset]&0x1f == 0x1f {
+			return nil, false
+		}
+
+		v := tlv{offset: offset, lengthOffset: offset + 1, contentOffset: offset + 2}
+
+		length := int(b[offset+1])
+		if length >= 0x80 {
+			n := length & 0x7f
+			if n == 0 || n > 3 || v.contentOffset+n > end {
+				return nil, false
+			}
+			length = 0
+			for _, l := range b[v.contentOffset : v.contentOffset+n] {
+				length = length<<8 | int(l)
+			}
+			v.contentOffset += n
+		}
+
+		v.end = v.contentOffset + length
+		if v.end > end {
+			return nil, false
+		}
+
+		values = append(values, v)
+		offset = v.end
+	}
+
+	return values, true
+}
+
+// walkTLV returns the ASN.1 values in b, including the ones in
+// constructed values and in OCTET STRINGs which have DER in them
+func walkTLV(b []byte, start, end int) ([]tlv, bool) {
+	values, ok := children(b, start, end)
+	if !ok {
+		return nil, false
+	}
+
+	all := []tlv{}
+	for _, v := range values {
+		all = append(all, v)
+
+		constructed := v.tag(b)&0x20 != 0
+		if !constructed && v.tag(b) != 0x04 {
+			continue
+		}
+
+		inner, ok := walkTLV(b, v.contentOffset, v.end)
+		if ok {
+			all = append(all, inner...)
+		} else if constructed {
+			return nil, false
+		}
+	}
+
+	return all, true
+}
+
+// corruptLength changes the first length byte of a random ASN.1 value
+// in the payload
+func corruptLength(rng *rand.Rand, payload []byte) {
+	values, ok := walkTLV(payload, 0, len(payload))
+	if !ok || len(values) == 0 {
+		flipBits(rng, payload)
+		return
+	}
+
+	v := values[rng.Intn(len(values))]
+	payload[v.lengthOffset] ^= byte(1 + rng.Intn(255))
+}
+
+// attributeValue returns the value of an attribute, which is a SEQUENCE
+// of type, version and value
+func attributeValue(b []byte, v tlv) (tlv, bool) {
+	if v.tag(b) != 0x30 {
+		return tlv{}, false
+	}
+
+	fields, ok := children(b, v.contentOffset, v.end)
+	if !ok || len(fields) != 3 {
+		return tlv{}, false
+	}
+
+	if fields[0].tag(b) != 0x02 || fields[1].tag(b) != 0x02 || fields[2].tag(b) != 0x04 {
+		return tlv{}, false
+	}
+
+	return fields[2], true
+}
+
+// swapAttributes swaps the values of two attributes in a SET of
+// attributes which have the same length, or the positions of two
+// attributes when there are no such values. The length of the payload
+// does not change.
+func swapAttributes(rng *rand.Rand, payload []byte) {
+	values, ok := walkTLV(payload, 0, len(payload))
+	if !ok {
+		flipBits(rng, payload)
+		return
+	}
+
+	type pair struct{ a, b tlv }
+	sameLength := []pair{}
+	positions := []pair{}
+
+	for _, set := range values {
+		// Attributes are in a SET, or a SEQUENCE without Apple encoding
+		if set.tag(payload) != 0x31 && set.tag(payload) != 0x30 {
+			continue
+		}
+
+		attributes, ok := children(payload, set.contentOffset, set.end)
+		if !ok || len(attributes) < 2 {
+			continue
+		}
+
+		attributeValues := []tlv{}
+		for _, a := range attributes {
+			value, ok := attributeValue(payload, a)
+			if !ok {
+				break
+			}
+			attributeValues = append(attributeValues, value)
+		}
+		if len(attributeValues) != len(attributes) {
+			continue
+		}
+
+		for i := range attributes {
+			for j := i + 1; j < len(attributes); j++ {
+				a, b := attributes[i], attributes[j]
+				if bytes.Equal(payload[a.offset:a.end], payload[b.offset:b.end]) {
+					continue
+				}
+				positions = append(positions, pair{a, b})
+
+				va, vb := attributeValues[i], attributeValues[j]
+				if va.end-va.offset == vb.end-vb.offset && !bytes.Equal(payload[va.offset:va.end], payload[vb.offset:vb.end]) {
+					sameLength = append(sameLength, pair{va, vb})
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(sameLength) > 0:
+		p := sameLength[rng.Intn(len(sameLength))]
+		va := append([]byte{}, payload[p.a.offset:p.a.end]...)
+		copy(payload[p.a.offset:], payload[p.b.offset:p.b.end])
+		copy(payload[p.b.offset:], va)
+	case len(positions) > 0:
+		p := positions[rng.Intn(len(positions))]
+		swapped := []byte{}
+		swapped = append(swapped, payload[p.b.offset:p.b.end]...)
+		swapped = append(swapped, payload[p.a.end:p.b.offset]...)
+		swapped = append(swapped, payload[p.a.offset:p.a.end]...)
+		copy(payload[p.a.offset:], swapped)
+	default:
+		flipBits(rng, payload)
+	}
+}
@@ -0,0 +1,91 @@
+package receipt
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/aktsk/nolmandy/receipt"
+	"github.com/fullsailor/pkcs7"
+)
+
+func TestFuzz(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	rcpt, err := Encode([]byte(receiptJSON), privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutants, err := Fuzz(rcpt, FuzzConfig{Seed: 1, N: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mutants) != 40 {
+		t.Fatalf("Wrong number of mutants: %d", len(mutants))
+	}
+
+	again, err := Fuzz(rcpt, FuzzConfig{Seed: 1, N: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutations := map[string]bool{}
+	for i, m := range mutants {
+		if m.ReceiptData == rcpt {
+			t.Fatalf("Mutant %d is not mutated: %s", i, m.Mutation)
+		}
+		if m.ReceiptData != again[i].ReceiptData {
+			t.Fatalf("Mutant %d should be the same with the same seed", i)
+		}
+		if _, err := receipt.Parse(cert, m.ReceiptData); err == nil {
+			t.Fatalf("Mutant %d should not be verified: %s", i, m.Mutation)
+		}
+		mutations[m.Mutation] = true
+	}
+
+	if len(mutations) != len(Mutations) {
+		t.Fatalf("All the mutations should be chosen: %v", mutations)
+	}
+}
+
+func TestFuzzResign(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	rcpt, err := Encode([]byte(receiptJSON), privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := FuzzConfig{
+		Seed:      1,
+		N:         10,
+		Mutations: []string{MutationBitFlip, MutationLength, MutationSwap},
+		Key:       privKey,
+		Cert:      cert,
+	}
+	mutants, err := Fuzz(rcpt, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range mutants {
+		data, err := base64.StdEncoding.DecodeString(m.ReceiptData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p7, err := pkcs7.Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p7.Verify(); err != nil {
+			t.Fatalf("Mutant %d should be re-signed: %v", i, err)
+		}
+	}
+
+	if _, err := Fuzz(rcpt, FuzzConfig{N: 1, Mutations: []string{"unknown"}}); err == nil {
+		t.Fatal("Unknown mutation should be an error")
+	}
+}
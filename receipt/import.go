@@ -0,0 +1,197 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Import converts a verifyReceipt response into a receipt. in_app of the
+// receipt and latest_receipt_info are merged by transaction_id, and all
+// of their fields are kept. data can also be the receipt of a response,
+// or base64 encoded receipt data whose attributes are returned as Decode
+// does.
+func Import(data []byte) (*Receipt, *ReceiptAttributes, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		return Decode(string(data))
+	}
+
+	value, err := toJSONValue(json.RawMessage(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	doc := value.(map[string]interface{})
+
+	if status, ok := doc["status"].(json.Number); ok && status.String() != "0" {
+		return nil, nil, fmt.Errorf("verifyReceipt response has status %s", status)
+	}
+
+	receiptMap := doc
+	if r, ok := doc["receipt"].(map[string]interface{}); ok {
+		receiptMap = r
+	}
+
+	inApps, _ := receiptMap["in_app"].([]interface{})
+	latest, _ := doc["latest_receipt_info"].([]interface{})
+
+	index := map[interface{}]int{}
+	for i, inApp := range inApps {
+		if m, ok := inApp.(map[string]interface{}); ok {
+			index[m["transaction_id"]] = i
+		}
+	}
+
+	for _, inApp := range latest {
+		m, ok := inApp.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if i, ok := index[m["transaction_id"]]; ok {
+			inApps[i] = m
+			continue
+		}
+		index[m["transaction_id"]] = len(inApps)
+		inApps = append(inApps, m)
+	}
+
+	// Newer responses have web_order_line_item_id as a string
+	for _, inApp := range inApps {
+		if m, ok := inApp.(map[string]interface{}); ok {
+			if id, ok := m["web_order_line_item_id"].(string); ok {
+				m["web_order_line_item_id"] = json.Number(id)
+			}
+		}
+	}
+
+	receiptMap["in_app"] = inApps
+
+	receiptJSON, err := json.Marshal(receiptMap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rcpt, err := UnmarshalReceipt(receiptJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attributes, err := UnmarshalAttributes(receiptJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rcpt, attributes, nil
+}
+
+// Anonymizer rewrites the IDs, the bundle ID and the dates of receipts
+// with a mapping keyed by a secret. The same key maps the same values to
+// the same values, so transactions of receipts anonymized with a key
+// still refer to each other. Dates are shifted by the same number of
+// days, so periods of subscriptions are kept.
+type Anonymizer struct {
+	key []byte
+}
+
+// NewAnonymizer returns an Anonymizer with key
+func NewAnonymizer(key []byte) *Anonymizer {
+	return &Anonymizer{key: key}
+}
+
+// Anonymize rewrites r in place. Product IDs which start with the bundle
+// ID get the anonymized bundle ID instead, and the others are kept as
+// they are. The opaque value and the SHA-1 hash, which are bound to the
+// device, are removed. Attributes r does not have fields for are not
+// anonymized.
+func (a *Anonymizer) Anonymize(r *Receipt) {
+	bundleID := a.bundleID(r.BundleID)
+	for _, inApp := range r.InApp {
+		if r.BundleID != "" && strings.HasPrefix(inApp.ProductID, r.BundleID+".") {
+			inApp.ProductID = bundleID + strings.TrimPrefix(inApp.ProductID, r.BundleID)
+		}
+	}
+
+	r.BundleID = bundleID
+	r.AdamID = a.number(r.AdamID)
+	r.AppItemID = a.number(r.AppItemID)
+	r.DownloadID = a.number(r.DownloadID)
+	r.VersionExternalIdentifier = a.number(r.VersionExternalIdentifier)
+	r.OpaqueValue = nil
+	r.SHA1Hash = nil
+
+	dates := []*Date{&r.ReceiptCreationDate, &r.RequestDate, &r.OriginalPurchaseDate, &r.ExpirationDate, &r.PreorderDate}
+
+	for _, inApp := range r.InApp {
+		inApp.TransactionID = a.id(inApp.TransactionID)
+		inApp.OriginalTransactionID = a.id(inApp.OriginalTransactionID)
+		inApp.WebOrderLineItemID = a.number(inApp.WebOrderLineItemID)
+
+		dates = append(dates, &inApp.PurchaseDate, &inApp.OriginalPurchaseDate, &inApp.ExpiresDate, &inApp.CancellationDate)
+	}
+
+	for _, d := range dates {
+		if !d.IsZero() {
+			d.Time = a.date(d.Time)
+		}
+	}
+}
+
+func (a *Anonymizer) mac(kind, value string) []byte {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(kind + ":" + value))
+	return h.Sum(nil)
+}
+
+// id maps a transaction ID to an ID of the same length. IDs of digits are
+// mapped to digits with the same leading digit.
+func (a *Anonymizer) id(s string) string {
+	if s == "" {
+		return s
+	}
+
+	if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+		mapped := hex.EncodeToString(a.mac("id", s))
+		for len(mapped) < len(s) {
+			mapped += mapped
+		}
+		return mapped[:len(s)]
+	}
+
+	mapped := []byte(s[:1])
+	for i, sum := 0, a.mac("id", s); len(mapped) < len(s); i++ {
+		if i == len(sum) {
+			i, sum = 0, a.mac("id", string(sum))
+		}
+		mapped = append(mapped, '0'+sum[i]%10)
+	}
+	return string(mapped)
+}
+
+func (a *Anonymizer) number(n int64) int64 {
+	if n <= 0 {
+		return n
+	}
+
+	mapped, _ := strconv.ParseInt(a.id(strconv.FormatInt(n, 10)), 10, 64)
+	return mapped
+}
+
+func (a *Anonymizer) bundleID(s string) string {
+	if s == "" {
+		return s
+	}
+	return "com.example." + hex.EncodeToString(a.mac("bundle_id", s))[:8]
+}
+
+// date shifts t by up to a year. The offset depends only on the key.
+func (a *Anonymizer) date(t time.Time) time.Time {
+	days := binary.BigEndian.Uint64(a.mac("date", "")) % 730
+	return t.AddDate(0, 0, int(days)-365)
+}
@@ -0,0 +1,221 @@
+package receipt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const verifyReceiptResponseJSON = `{
+  "status": 0,
+  "environment": "Production",
+  "receipt": {
+    "receipt_type": "Production",
+    "adam_id": 1234567890,
+    "app_item_id": 1234567890,
+    "bundle_id": "com.example.game",
+    "application_version": "3",
+    "download_id": 80012345678901,
+    "version_external_identifier": 825000001,
+    "original_application_version": "1",
+    "receipt_creation_date": "2018-04-01 12:00:00 Etc/GMT",
+    "receipt_creation_date_ms": "1522584000000",
+    "request_date": "2018-04-02 12:00:00 Etc/GMT",
+    "original_purchase_date": "2018-01-01 12:00:00 Etc/GMT",
+    "preorder_date": "2017-12-01 12:00:00 Etc/GMT",
+    "in_app": [
+      {
+        "quantity": "1",
+        "product_id": "com.example.game.monthly",
+        "transaction_id": "120000400000001",
+        "original_transaction_id": "120000400000001",
+        "purchase_date": "2018-01-01 12:00:00 Etc/GMT",
+        "purchase_date_ms": "1514808000000",
+        "original_purchase_date": "2018-01-01 12:00:00 Etc/GMT",
+        "expires_date": "2018-02-01 12:00:00 Etc/GMT",
+        "web_order_line_item_id": "120000100000001",
+        "is_trial_period": "true",
+        "is_in_intro_offer_period": "false"
+      }
+    ]
+  },
+  "latest_receipt_info": [
+    {
+      "quantity": "1",
+      "product_id": "com.example.game.monthly",
+      "transaction_id": "120000400000001",
+      "original_transaction_id": "120000400000001",
+      "purchase_date": "2018-01-01 12:00:00 Etc/GMT",
+      "original_purchase_date": "2018-01-01 12:00:00 Etc/GMT",
+      "expires_date": "2018-02-01 12:00:00 Etc/GMT",
+      "cancellation_date": "2018-01-15 12:00:00 Etc/GMT",
+      "web_order_line_item_id": "120000100000001",
+      "is_trial_period": "true",
+      "is_in_intro_offer_period": "false"
+    },
+    {
+      "quantity": "1",
+      "product_id": "com.example.game.monthly",
+      "transaction_id": "120000500000002",
+      "original_transaction_id": "120000400000001",
+      "purchase_date": "2018-02-01 12:00:00 Etc/GMT",
+      "original_purchase_date": "2018-01-01 12:00:00 Etc/GMT",
+      "expires_date": "2018-03-01 12:00:00 Etc/GMT",
+      "web_order_line_item_id": "120000100000002",
+      "is_trial_period": "false",
+      "is_in_intro_offer_period": "false",
+      "is_upgraded": "true",
+      "subscription_group_identifier": "20000001",
+      "promotional_offer_id": "monthly_promo",
+      "offer_code_ref_name": "spring_code",
+      "in_app_ownership_type": "FAMILY_SHARED"
+    }
+  ],
+  "pending_renewal_info": []
+}`
+
+func TestImport(t *testing.T) {
+	rcpt, _, err := Import([]byte(verifyReceiptResponseJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rcpt.BundleID != "com.example.game" {
+		t.Fatalf("Wrong bundle_id: %s", rcpt.BundleID)
+	}
+
+	if len(rcpt.InApp) != 2 {
+		t.Fatalf("in_app and latest_receipt_info should be merged: %d", len(rcpt.InApp))
+	}
+
+	if rcpt.InApp[0].CancellationDate.IsZero() {
+		t.Fatal("latest_receipt_info should be preferred")
+	}
+
+	if rcpt.InApp[1].WebOrderLineItemID != 120000100000002 {
+		t.Fatalf("Wrong web_order_line_item_id: %d", rcpt.InApp[1].WebOrderLineItemID)
+	}
+
+	if !rcpt.PreorderDate.Equal(time.Date(2017, 12, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong preorder_date: %v", rcpt.PreorderDate)
+	}
+
+	expected := InApp{
+		IsUpgraded:                  "true",
+		SubscriptionGroupIdentifier: "20000001",
+		PromotionalOfferID:          "monthly_promo",
+		OfferCodeRefName:            "spring_code",
+		InAppOwnershipType:          "FAMILY_SHARED",
+	}
+	inApp := rcpt.InApp[1]
+	actual := InApp{
+		IsUpgraded:                  inApp.IsUpgraded,
+		SubscriptionGroupIdentifier: inApp.SubscriptionGroupIdentifier,
+		PromotionalOfferID:          inApp.PromotionalOfferID,
+		OfferCodeRefName:            inApp.OfferCodeRefName,
+		InAppOwnershipType:          inApp.InAppOwnershipType,
+	}
+	if actual != expected {
+		t.Fatalf("Fields of latest_receipt_info should be kept: %+v", actual)
+	}
+
+	if _, _, err := Import([]byte(`{"status": 21003}`)); err == nil {
+		t.Fatal("Response with an error status should not be imported")
+	}
+}
+
+func TestImportReceiptData(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	receiptJSON := []byte(`{
+  "bundle_id": "com.example.game",
+  "extra_attributes": [{"type": 1234, "utf8_string": "unknown"}],
+  "in_app": []
+}`)
+
+	receiptData, err := Encode(receiptJSON, privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt, attributes, err := Import([]byte(receiptData + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rcpt.BundleID != "com.example.game" {
+		t.Fatalf("Wrong bundle_id: %s", rcpt.BundleID)
+	}
+
+	imported, err := Marshal(rcpt, attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := UnmarshalAttributes(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, extra := range a.Extra {
+		found = found || extra.Type == 1234
+	}
+	if !found {
+		t.Fatalf("Unknown attributes should be kept: %s", imported)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	anonymize := func(key string) string {
+		rcpt, _, err := Import([]byte(verifyReceiptResponseJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		NewAnonymizer([]byte(key)).Anonymize(rcpt)
+
+		receiptJSON, err := Marshal(rcpt, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := UnmarshalReceipt(receiptJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first, second := r.InApp[0], r.InApp[1]
+
+		if r.BundleID == "com.example.game" || r.DownloadID == 80012345678901 {
+			t.Fatalf("Receipt should be anonymized: %s", receiptJSON)
+		}
+
+		if first.TransactionID == "120000400000001" || len(first.TransactionID) != len("120000400000001") {
+			t.Fatalf("Wrong transaction_id: %s", first.TransactionID)
+		}
+
+		if second.OriginalTransactionID != first.TransactionID {
+			t.Fatalf("original_transaction_id should be mapped consistently: %s", second.OriginalTransactionID)
+		}
+
+		if first.ProductID != r.BundleID+".monthly" {
+			t.Fatalf("product_id should have the anonymized bundle ID: %s", first.ProductID)
+		}
+
+		purchaseDate := first.PurchaseDate.Time
+		expiresDate := first.ExpiresDate.Time
+		if purchaseDate.Equal(time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)) || !expiresDate.Equal(purchaseDate.AddDate(0, 0, 31)) {
+			t.Fatalf("Dates should be shifted consistently: %v %v", purchaseDate, expiresDate)
+		}
+
+		return fmt.Sprintf("%s %s %v", r.BundleID, first.TransactionID, purchaseDate)
+	}
+
+	if anonymize("key") != anonymize("key") {
+		t.Fatal("The same key should result in the same receipt")
+	}
+
+	if anonymize("key") == anonymize("another key") {
+		t.Fatal("Another key should result in another receipt")
+	}
+}
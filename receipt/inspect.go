@@ -0,0 +1,308 @@
+package receipt
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+)
+
+// Report describes what is in receipt data, from the PKCS#7 container
+// to each attribute of the payload
+type Report struct {
+	IndefiniteLength bool                 `json:"indefinite_length"`
+	Certificates     []*CertificateReport `json:"certificates"`
+	Signers          []*SignerReport      `json:"signers"`
+	Payload          string               `json:"payload"`
+	Attributes       []*AttributeReport   `json:"attributes"`
+}
+
+// CertificateReport describes a certificate in the container
+type CertificateReport struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+}
+
+// SignerReport describes a signer of the container
+type SignerReport struct {
+	Issuer             string     `json:"issuer"`
+	SerialNumber       string     `json:"serial_number"`
+	DigestAlgorithm    string     `json:"digest_algorithm"`
+	SignatureAlgorithm string     `json:"signature_algorithm"`
+	SigningTime        *time.Time `json:"signing_time,omitempty"`
+	Signature          string     `json:"signature"`
+}
+
+// AttributeReport describes an attribute of the payload. Raw is the hex
+// of the value as encoded, and Value is the decoded value if the value is
+// an INTEGER or a string. The attributes of an in-app purchase receipt
+// are in InApp.
+type AttributeReport struct {
+	Type    int                `json:"type"`
+	Name    string             `json:"name,omitempty"`
+	Version int                `json:"version"`
+	Tag     string             `json:"tag"`
+	Raw     string             `json:"raw,omitempty"`
+	Value   interface{}        `json:"value,omitempty"`
+	InApp   []*AttributeReport `json:"in_app,omitempty"`
+}
+
+var attributeNames = map[int]string{
+	0:    "receipt_type",
+	2:    "bundle_id",
+	3:    "application_version",
+	4:    "opaque_value",
+	5:    "sha1_hash",
+	12:   "receipt_creation_date",
+	17:   "in_app",
+	18:   "original_purchase_date",
+	19:   "original_application_version",
+	21:   "expiration_date",
+	1701: "quantity",
+	1702: "product_id",
+	1703: "transaction_id",
+	1704: "purchase_date",
+	1705: "original_transaction_id",
+	1706: "original_purchase_date",
+	1708: "expires_date",
+	1711: "web_order_line_item_id",
+	1712: "cancellation_date",
+	1719: "is_in_intro_offer_period",
+}
+
+var algorithmNames = map[string]string{
+	"1.3.14.3.2.26":          "SHA-1",
+	"2.16.840.1.101.3.4.2.1": "SHA-256",
+	"1.2.840.113549.1.1.1":   "RSA",
+	"1.2.840.113549.1.1.5":   "SHA1-RSA",
+	"1.2.840.113549.1.1.11":  "SHA256-RSA",
+}
+
+var oidSigningTime = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+// Inspect reports what is in base64 encoded receipt data. Like Decode,
+// it does not verify the signature.
+func Inspect(receiptData string) (*Report, error) {
+	data, err := base64.StdEncoding.DecodeString(receiptData)
+	if err != nil {
+		return nil, err
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{
+		IndefiniteLength: len(data) > 1 && data[1] == 0x80,
+		Certificates:     []*CertificateReport{},
+		Signers:          []*SignerReport{},
+	}
+
+	for _, cert := range p7.Certificates {
+		r.Certificates = append(r.Certificates, &CertificateReport{
+			Subject:            cert.Subject.String(),
+			Issuer:             cert.Issuer.String(),
+			SerialNumber:       cert.SerialNumber.String(),
+			NotBefore:          cert.NotBefore,
+			NotAfter:           cert.NotAfter,
+			SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		})
+	}
+
+	for _, signer := range p7.Signers {
+		s := &SignerReport{
+			Issuer:             issuerName(signer.IssuerAndSerialNumber.IssuerName.FullBytes),
+			SerialNumber:       signer.IssuerAndSerialNumber.SerialNumber.String(),
+			DigestAlgorithm:    algorithmName(signer.DigestAlgorithm.Algorithm),
+			SignatureAlgorithm: algorithmName(signer.DigestEncryptionAlgorithm.Algorithm),
+			Signature:          hex.EncodeToString(signer.EncryptedDigest),
+		}
+
+		for _, attr := range signer.AuthenticatedAttributes {
+			if !attr.Type.Equal(oidSigningTime) {
+				continue
+			}
+			var signingTime time.Time
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, &signingTime); err == nil {
+				s.SigningTime = &signingTime
+			}
+		}
+
+		r.Signers = append(r.Signers, s)
+	}
+
+	var container asn1.RawValue
+	if _, err := asn1.Unmarshal(p7.Content, &container); err != nil {
+		return nil, err
+	}
+	r.Payload = tagName(container.Tag)
+
+	if r.Attributes, err = inspectAttributes(p7.Content); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func algorithmName(oid asn1.ObjectIdentifier) string {
+	if name, ok := algorithmNames[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
+
+func issuerName(der []byte) string {
+	var rdns pkix.RDNSequence
+	if _, err := asn1.Unmarshal(der, &rdns); err != nil {
+		return hex.EncodeToString(der)
+	}
+
+	var name pkix.Name
+	name.FillFromRDNSequence(&rdns)
+	return name.String()
+}
+
+// inspectAttributes reports a SET or a SEQUENCE of attributes
+func inspectAttributes(data []byte) ([]*AttributeReport, error) {
+	attributes, err := decodeAttributes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []*AttributeReport{}
+	for _, ra := range attributes {
+		report := &AttributeReport{
+			Type:    ra.Type,
+			Name:    attributeNames[ra.Type],
+			Version: ra.Version,
+			Raw:     hex.EncodeToString(ra.Value),
+		}
+
+		var value asn1.RawValue
+		rest, err := asn1.Unmarshal(ra.Value, &value)
+		if err != nil || len(rest) > 0 || value.Class != asn1.ClassUniversal {
+			// Values like opaque_value are not encoded in ASN.1
+			report.Tag = "raw"
+			reports = append(reports, report)
+			continue
+		}
+		report.Tag = tagName(value.Tag)
+
+		switch value.Tag {
+		case asn1.TagInteger:
+			var n *big.Int
+			if _, err := asn1.Unmarshal(ra.Value, &n); err == nil {
+				if n.IsInt64() {
+					report.Value = n.Int64()
+				} else {
+					report.Value = n.String()
+				}
+			}
+		case asn1.TagUTF8String, asn1.TagIA5String, asn1.TagPrintableString:
+			var s string
+			if _, err := asn1.Unmarshal(ra.Value, &s); err == nil {
+				report.Value = s
+			}
+		case asn1.TagSet, asn1.TagSequence:
+			if ra.Type == 17 {
+				if report.InApp, err = inspectAttributes(ra.Value); err != nil {
+					return nil, err
+				}
+				report.Raw = ""
+			}
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// WriteText writes the report as an indented tree
+func (r *Report) WriteText(w io.Writer) {
+	encoding := "DER"
+	if r.IndefiniteLength {
+		encoding = "BER (indefinite length)"
+	}
+
+	fmt.Fprintf(w, "PKCS#7 signedData, %s\n", encoding)
+
+	fmt.Fprintln(w, "Certificates")
+	for i, c := range r.Certificates {
+		fmt.Fprintf(w, "  [%d] Subject: %s\n", i, c.Subject)
+		fmt.Fprintf(w, "      Issuer: %s\n", c.Issuer)
+		fmt.Fprintf(w, "      Serial number: %s\n", c.SerialNumber)
+		fmt.Fprintf(w, "      Validity: %s - %s\n", c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339))
+		fmt.Fprintf(w, "      Signature algorithm: %s\n", c.SignatureAlgorithm)
+	}
+
+	fmt.Fprintln(w, "Signers")
+	for i, s := range r.Signers {
+		fmt.Fprintf(w, "  [%d] Issuer: %s\n", i, s.Issuer)
+		fmt.Fprintf(w, "      Serial number: %s\n", s.SerialNumber)
+		fmt.Fprintf(w, "      Digest algorithm: %s\n", s.DigestAlgorithm)
+		fmt.Fprintf(w, "      Signature algorithm: %s\n", s.SignatureAlgorithm)
+		if s.SigningTime != nil {
+			fmt.Fprintf(w, "      Signing time: %s\n", s.SigningTime.Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "      Signature: %s\n", s.Signature)
+	}
+
+	fmt.Fprintf(w, "Payload %s\n", r.Payload)
+	writeAttributes(w, r.Attributes, "  ")
+}
+
+func writeAttributes(w io.Writer, attributes []*AttributeReport, indent string) {
+	for _, a := range attributes {
+		name := a.Name
+		if name == "" {
+			name = "unknown"
+		}
+
+		fmt.Fprintf(w, "%s%d %s (version %d) %s", indent, a.Type, name, a.Version, a.Tag)
+		switch v := a.Value.(type) {
+		case string:
+			fmt.Fprintf(w, " %q", v)
+		case int64:
+			fmt.Fprintf(w, " %d", v)
+		}
+		fmt.Fprintln(w)
+
+		if a.Raw != "" {
+			fmt.Fprintf(w, "%s  %s\n", indent, a.Raw)
+		}
+
+		writeAttributes(w, a.InApp, indent+"  ")
+	}
+}
+
+func tagName(tag int) string {
+	switch tag {
+	case asn1.TagInteger:
+		return "INTEGER"
+	case asn1.TagOctetString:
+		return "OCTET STRING"
+	case asn1.TagUTF8String:
+		return "UTF8String"
+	case asn1.TagPrintableString:
+		return "PrintableString"
+	case asn1.TagIA5String:
+		return "IA5String"
+	case asn1.TagSequence:
+		return "SEQUENCE"
+	case asn1.TagSet:
+		return "SET"
+	}
+	return fmt.Sprintf("[%d]", tag)
+}
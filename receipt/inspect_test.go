@@ -0,0 +1,69 @@
+package receipt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	rcpt, err := Encode([]byte(receiptJSON), privKey, cert, WithIndefiniteLength(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Inspect(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.IndefiniteLength {
+		t.Fatal("Receipt should be in indefinite length")
+	}
+
+	if len(r.Certificates) != 1 || r.Certificates[0].Subject != cert.Subject.String() {
+		t.Fatalf("Wrong certificates: %+v", r.Certificates)
+	}
+
+	if len(r.Signers) != 1 || r.Signers[0].DigestAlgorithm != "SHA-1" || r.Signers[0].SigningTime == nil {
+		t.Fatalf("Wrong signers: %+v", r.Signers)
+	}
+
+	if r.Signers[0].SerialNumber != cert.SerialNumber.String() {
+		t.Fatalf("Wrong serial number of the signer: %s", r.Signers[0].SerialNumber)
+	}
+
+	if r.Payload != "SET" {
+		t.Fatalf("Wrong payload: %s", r.Payload)
+	}
+
+	inApps := 0
+	for _, a := range r.Attributes {
+		switch a.Type {
+		case 2:
+			if a.Name != "bundle_id" || a.Tag != "UTF8String" || a.Value != "jp.aktsk.kalvados.test" || a.Raw == "" {
+				t.Fatalf("Wrong bundle_id: %+v", a)
+			}
+		case 17:
+			inApps++
+			if len(a.InApp) == 0 {
+				t.Fatalf("in_app should have attributes: %+v", a)
+			}
+		}
+	}
+
+	if inApps != 3 {
+		t.Fatalf("Wrong in_app: %d", inApps)
+	}
+
+	var b bytes.Buffer
+	r.WriteText(&b)
+
+	for _, s := range []string{"BER (indefinite length)", "Signing time", `2 bundle_id (version 0) UTF8String "jp.aktsk.kalvados.test"`, "1711 web_order_line_item_id (version 0) INTEGER 220000075821143", "1702 product_id"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatalf("%q should be in the report:\n%s", s, b.String())
+		}
+	}
+}
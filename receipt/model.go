@@ -0,0 +1,252 @@
+package receipt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+// ModelVersion is the version of the JSON receipt data which this
+// version of kalvados reads. JSON receipt data without version is read as
+// the current version.
+const ModelVersion = 1
+
+// ErrUnsupportedVersion is returned when JSON receipt data is of a newer
+// version than ModelVersion
+var ErrUnsupportedVersion = errors.New("unsupported version of JSON receipt data")
+
+// Receipt is an app receipt read from JSON receipt data. It has all the
+// fields of receipts in verifyReceipt responses, in the same JSON form
+// except dates.
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/receipt
+type Receipt struct {
+	Version                    int      `json:"version,omitempty"`
+	ReceiptType                string   `json:"receipt_type"`
+	AdamID                     int64    `json:"adam_id"`
+	AppItemID                  int64    `json:"app_item_id"`
+	BundleID                   string   `json:"bundle_id"`
+	ApplicationVersion         string   `json:"application_version"`
+	DownloadID                 int64    `json:"download_id"`
+	VersionExternalIdentifier  int64    `json:"version_external_identifier"`
+	OriginalApplicationVersion string   `json:"original_application_version"`
+	ReceiptCreationDate        Date     `json:"receipt_creation_date"`
+	RequestDate                Date     `json:"request_date"`
+	OriginalPurchaseDate       Date     `json:"original_purchase_date"`
+	ExpirationDate             Date     `json:"expiration_date"`
+	PreorderDate               Date     `json:"preorder_date"`
+	OpaqueValue                []byte   `json:"opaque_value,omitempty"`
+	SHA1Hash                   []byte   `json:"sha1_hash,omitempty"`
+	InApp                      []*InApp `json:"in_app"`
+}
+
+// InApp is an in-app purchase receipt read from JSON receipt data
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/receipt/in_app
+type InApp struct {
+	Quantity                    int64  `json:"quantity,string"`
+	ProductID                   string `json:"product_id"`
+	TransactionID               string `json:"transaction_id"`
+	OriginalTransactionID       string `json:"original_transaction_id"`
+	PurchaseDate                Date   `json:"purchase_date"`
+	OriginalPurchaseDate        Date   `json:"original_purchase_date"`
+	ExpiresDate                 Date   `json:"expires_date"`
+	WebOrderLineItemID          int64  `json:"web_order_line_item_id,omitempty"`
+	IsTrialPeriod               string `json:"is_trial_period"`
+	IsInIntroOfferPeriod        string `json:"is_in_intro_offer_period,omitempty"`
+	IsUpgraded                  string `json:"is_upgraded,omitempty"`
+	CancellationDate            Date   `json:"cancellation_date"`
+	CancellationReason          string `json:"cancellation_reason,omitempty"`
+	PromotionalOfferID          string `json:"promotional_offer_id,omitempty"`
+	OfferCodeRefName            string `json:"offer_code_ref_name,omitempty"`
+	SubscriptionGroupIdentifier string `json:"subscription_group_identifier,omitempty"`
+	InAppOwnershipType          string `json:"in_app_ownership_type,omitempty"`
+}
+
+// Date is a date in JSON receipt data. It is read from the format of the
+// App Store like "2018-01-01 00:00:00 Etc/GMT", RFC 3339, or milliseconds
+// since the epoch, and written in the format of the App Store. Empty
+// dates are zero.
+type Date struct {
+	time.Time
+}
+
+// MarshalJSON writes the date in the format of the App Store
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte(`""`), nil
+	}
+	s, _, _ := FormatDate(d.Time)
+	return []byte(strconv.Quote(s)), nil
+}
+
+// UnmarshalJSON reads the date
+func (d *Date) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	if s == "" || s == "null" {
+		d.Time = time.Time{}
+		return nil
+	}
+
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		d.Time = time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC()
+		return nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05 Etc/GMT", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			d.Time = t.UTC()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid date: %s", s)
+}
+
+// UnmarshalReceipt reads JSON receipt data into a receipt
+func UnmarshalReceipt(receiptJSON []byte) (*Receipt, error) {
+	r := &Receipt{}
+	if err := json.Unmarshal(receiptJSON, r); err != nil {
+		return nil, err
+	}
+
+	if r.Version > ModelVersion {
+		return nil, fmt.Errorf("version %d: %v", r.Version, ErrUnsupportedVersion)
+	}
+
+	return r, nil
+}
+
+// FromNolmandy converts nolmandy's receipt into a receipt
+func FromNolmandy(r *receipt.Receipt) *Receipt {
+	rcpt := &Receipt{
+		Version:                    ModelVersion,
+		ReceiptType:                r.ReceiptType,
+		AdamID:                     r.AdamID,
+		AppItemID:                  r.AppItemID,
+		BundleID:                   r.BundleID,
+		ApplicationVersion:         r.ApplicationVersion,
+		DownloadID:                 r.DownloadID,
+		VersionExternalIdentifier:  r.VersionExternalIdentifier,
+		OriginalApplicationVersion: r.OriginalApplicationVersion,
+		ReceiptCreationDate:        Date{time.Time(r.CreationDate.Date)},
+		RequestDate:                Date{time.Time(r.RequestDate.Date)},
+		OriginalPurchaseDate:       Date{time.Time(r.OriginalPurchaseDate.Date)},
+		ExpirationDate:             Date{time.Time(r.ExpirationDate)},
+		OpaqueValue:                r.OpaqueValue,
+		SHA1Hash:                   r.SHA1Hash,
+		InApp:                      []*InApp{},
+	}
+
+	for _, inApp := range r.InApp {
+		rcpt.InApp = append(rcpt.InApp, &InApp{
+			Quantity:              inApp.Quantity,
+			ProductID:             inApp.ProductID,
+			TransactionID:         inApp.TransactionID,
+			OriginalTransactionID: inApp.OriginalTransactionID,
+			PurchaseDate:          Date{time.Time(inApp.PurchaseDate.Date)},
+			OriginalPurchaseDate:  Date{time.Time(inApp.OriginalPurchaseDate.Date)},
+			ExpiresDate:           Date{time.Time(inApp.ExpiresDate.Date)},
+			WebOrderLineItemID:    inApp.WebOrderLineItemID,
+			IsTrialPeriod:         inApp.IsTrialPeriod,
+			CancellationDate:      Date{time.Time(inApp.CancellationDate.Date)},
+			CancellationReason:    inApp.CancellationReason,
+			IsInIntroOfferPeriod:  strconv.FormatBool(inApp.IsInIntroPrice),
+		})
+	}
+
+	return rcpt
+}
+
+// UpdateFromNolmandy sets the fields of r which nolmandy's receipt has
+// to the ones of n, like a receipt updated by a store, and keeps the
+// others, like preorder_date and is_upgraded. In-app purchase receipts
+// are matched by their order.
+func (r *Receipt) UpdateFromNolmandy(n *receipt.Receipt) {
+	u := FromNolmandy(n)
+	u.PreorderDate = r.PreorderDate
+
+	for i, inApp := range u.InApp {
+		if i >= len(r.InApp) {
+			break
+		}
+		old := r.InApp[i]
+		inApp.IsUpgraded = old.IsUpgraded
+		inApp.PromotionalOfferID = old.PromotionalOfferID
+		inApp.OfferCodeRefName = old.OfferCodeRefName
+		inApp.SubscriptionGroupIdentifier = old.SubscriptionGroupIdentifier
+		inApp.InAppOwnershipType = old.InAppOwnershipType
+	}
+
+	*r = *u
+}
+
+// Nolmandy converts r into nolmandy's receipt. Fields nolmandy's receipt
+// does not have, like preorder_date and is_upgraded, are dropped.
+func (r *Receipt) Nolmandy() (*receipt.Receipt, error) {
+	rcpt := &receipt.Receipt{
+		ReceiptType:                r.ReceiptType,
+		AdamID:                     r.AdamID,
+		AppItemID:                  r.AppItemID,
+		BundleID:                   r.BundleID,
+		ApplicationVersion:         r.ApplicationVersion,
+		DownloadID:                 r.DownloadID,
+		VersionExternalIdentifier:  r.VersionExternalIdentifier,
+		OriginalApplicationVersion: r.OriginalApplicationVersion,
+		OpaqueValue:                r.OpaqueValue,
+		SHA1Hash:                   r.SHA1Hash,
+		InApp:                      []*receipt.InApp{},
+	}
+
+	dates := []dateField{
+		{&rcpt.CreationDate.Date, r.ReceiptCreationDate.Time},
+		{&rcpt.RequestDate.Date, r.RequestDate.Time},
+		{&rcpt.OriginalPurchaseDate.Date, r.OriginalPurchaseDate.Time},
+		{&rcpt.ExpirationDate, r.ExpirationDate.Time},
+	}
+
+	for _, inApp := range r.InApp {
+		ia := &receipt.InApp{
+			Quantity:              inApp.Quantity,
+			ProductID:             inApp.ProductID,
+			TransactionID:         inApp.TransactionID,
+			OriginalTransactionID: inApp.OriginalTransactionID,
+			WebOrderLineItemID:    inApp.WebOrderLineItemID,
+			IsTrialPeriod:         inApp.IsTrialPeriod,
+			CancellationReason:    inApp.CancellationReason,
+			IsInIntroPrice:        inApp.IsInIntroOfferPeriod == "true",
+		}
+
+		dates = append(dates, []dateField{
+			{&ia.PurchaseDate.Date, inApp.PurchaseDate.Time},
+			{&ia.OriginalPurchaseDate.Date, inApp.OriginalPurchaseDate.Time},
+			{&ia.ExpiresDate.Date, inApp.ExpiresDate.Time},
+			{&ia.CancellationDate.Date, inApp.CancellationDate.Time},
+		}...)
+
+		rcpt.InApp = append(rcpt.InApp, ia)
+	}
+
+	for _, d := range dates {
+		if d.t.IsZero() {
+			continue
+		}
+		if err := SetDate(d.d, d.t); err != nil {
+			return nil, err
+		}
+	}
+
+	return rcpt, nil
+}
+
+// dateField is a date field of nolmandy's receipt and its value
+type dateField struct {
+	d json.Unmarshaler
+	t time.Time
+}
@@ -0,0 +1,189 @@
+package receipt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalReceipt(t *testing.T) {
+	rcpt, err := UnmarshalReceipt([]byte(`{
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "receipt_creation_date": "2018-01-01 00:00:00 Etc/GMT",
+  "original_purchase_date": "2018-01-01T00:00:00Z",
+  "request_date": "1514764800000",
+  "preorder_date": "",
+  "in_app": [
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000001",
+      "purchase_date": "2018-01-01T09:00:00+09:00",
+      "expires_date": "2018-02-01 00:00:00 Etc/GMT",
+      "is_in_intro_offer_period": "true",
+      "is_upgraded": "true"
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, d := range []Date{rcpt.ReceiptCreationDate, rcpt.OriginalPurchaseDate, rcpt.RequestDate, rcpt.InApp[0].PurchaseDate} {
+		if !d.Equal(date) {
+			t.Fatalf("Wrong date: %v", d)
+		}
+	}
+
+	if !rcpt.PreorderDate.IsZero() {
+		t.Fatalf("Empty date should be zero: %v", rcpt.PreorderDate)
+	}
+
+	if rcpt.InApp[0].IsUpgraded != "true" {
+		t.Fatal("is_upgraded should be read")
+	}
+
+	if _, err := UnmarshalReceipt([]byte(`{"version": 2}`)); err == nil || !strings.Contains(err.Error(), ErrUnsupportedVersion.Error()) {
+		t.Fatalf("Newer version should be an error: %v", err)
+	}
+
+	if _, err := UnmarshalReceipt([]byte(`{"receipt_creation_date": "2018/01/01"}`)); err == nil {
+		t.Fatal("Invalid date should be an error")
+	}
+}
+
+func TestNolmandy(t *testing.T) {
+	rcpt, err := UnmarshalReceipt([]byte(receiptJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inApp := range rcpt.InApp {
+		inApp.IsInIntroOfferPeriod = "false"
+	}
+	rcpt.InApp[0].ExpiresDate = Date{time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)}
+	rcpt.InApp[0].IsInIntroOfferPeriod = "true"
+	rcpt.InApp[1].IsInIntroOfferPeriod = "true"
+
+	nrcpt, err := rcpt.Nolmandy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !time.Time(nrcpt.CreationDate.Date).Equal(rcpt.ReceiptCreationDate.Time) {
+		t.Fatalf("Wrong receipt_creation_date: %v", time.Time(nrcpt.CreationDate.Date))
+	}
+
+	if !nrcpt.InApp[0].IsInIntroPrice || !time.Time(nrcpt.InApp[0].ExpiresDate.Date).Equal(rcpt.InApp[0].ExpiresDate.Time) {
+		t.Fatalf("Wrong in_app: %+v", nrcpt.InApp[0])
+	}
+
+	converted := FromNolmandy(nrcpt)
+	converted.Version = rcpt.Version
+	if !reflect.DeepEqual(converted, rcpt) {
+		t.Fatalf("Receipt should be converted back:\n%+v\n%+v", converted, rcpt)
+	}
+}
+
+func TestEncodeModel(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	rcpt, err := UnmarshalReceipt([]byte(receiptJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := rcpt.Encode(privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nrcpt, err := Unmarshal([]byte(receiptJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := EncodeReceipt(nrcpt, privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range changes {
+		if !strings.HasPrefix(c.Path, "signers[0].") {
+			t.Fatalf("Receipts should have the same payload: %s", c.Path)
+		}
+	}
+}
+
+func TestEncodeModelResponse(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	rcpt, err := UnmarshalReceipt([]byte(`{
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "preorder_date": "2018-01-01 00:00:00 Etc/GMT",
+  "in_app": [
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000002",
+      "original_transaction_id": "1000000000000001",
+      "purchase_date": "2018-02-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-03-01 00:00:00 Etc/GMT",
+      "is_upgraded": "true",
+      "promotional_offer_id": "offer",
+      "offer_code_ref_name": "code",
+      "subscription_group_identifier": "20000001",
+      "in_app_ownership_type": "FAMILY_SHARED"
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res Response
+	if _, err := rcpt.Encode(privKey, cert, WithResponse(&res)); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Receipt.PreorderDate != "2018-01-01 00:00:00 Etc/GMT" {
+		t.Fatalf("Wrong preorder_date: %s", res.Receipt.PreorderDate)
+	}
+
+	for _, inApps := range [][]*ResponseInApp{res.Receipt.InApp, res.LatestReceiptInfo} {
+		if len(inApps) != 1 {
+			t.Fatalf("Wrong in-app purchase receipts: %v", inApps)
+		}
+
+		ri := inApps[0]
+		if ri.IsUpgraded != "true" || ri.PromotionalOfferID != "offer" || ri.OfferCodeRefName != "code" ||
+			ri.SubscriptionGroupIdentifier != "20000001" || ri.InAppOwnershipType != "FAMILY_SHARED" {
+			t.Fatalf("Fields of the model should be in the response: %+v", ri)
+		}
+	}
+}
+
+func TestModelFieldsAreChecked(t *testing.T) {
+	for _, tc := range []struct {
+		v      interface{}
+		fields map[string]bool
+	}{
+		{Receipt{}, receiptFields},
+		{InApp{}, inAppFields},
+	} {
+		rt := reflect.TypeOf(tc.v)
+		for i := 0; i < rt.NumField(); i++ {
+			name := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+			if _, ok := tc.fields[name]; !ok {
+				t.Errorf("%s of %s should be known in the strict mode", name, rt.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package receipt
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Types of generated products. They are the same as the product types
+// of the appstore package.
+const (
+	ProductTypeConsumable    = "consumable"
+	ProductTypeNonConsumable = "non_consumable"
+	ProductTypeAutoRenewable = "auto_renewable"
+	ProductTypeNonRenewing   = "non_renewing"
+)
+
+// GeneratorProduct is a product of generated receipts
+type GeneratorProduct struct {
+	ProductID string
+	Type      string
+	// AddPeriod returns the end of a subscription period which starts at
+	// t. It is required for auto-renewable products.
+	AddPeriod func(t time.Time) time.Time
+}
+
+// Constraints constrain generated receipts. Zero values are replaced
+// with the ones of DefaultConstraints.
+type Constraints struct {
+	BundleID string
+	Products []GeneratorProduct
+	// Start and End are the range of the dates of receipts
+	Start time.Time
+	End   time.Time
+	// MinTransactions and MaxTransactions are the range of the number of
+	// transactions of a receipt. Receipts can have fewer transactions than
+	// MinTransactions unless Products has a consumable or non-renewing
+	// product, as the other products are purchased once, and
+	// subscriptions are renewed only until the receipt is created.
+	MinTransactions int
+	MaxTransactions int
+}
+
+func addMonth(t time.Time) time.Time {
+	return t.AddDate(0, 1, 0)
+}
+
+// DefaultConstraints returns constraints which generate receipts of a
+// product of each type in 2018
+func DefaultConstraints() Constraints {
+	return Constraints{
+		BundleID: "com.example",
+		Products: []GeneratorProduct{
+			{ProductID: "com.example.coins", Type: ProductTypeConsumable},
+			{ProductID: "com.example.premium", Type: ProductTypeNonConsumable},
+			{ProductID: "com.example.monthly", Type: ProductTypeAutoRenewable, AddPeriod: addMonth},
+			{ProductID: "com.example.season", Type: ProductTypeNonRenewing},
+		},
+		Start:           time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:             time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		MinTransactions: 1,
+		MaxTransactions: 10,
+	}
+}
+
+func (c Constraints) withDefaults() Constraints {
+	d := DefaultConstraints()
+	if c.BundleID == "" {
+		c.BundleID = d.BundleID
+	}
+	if len(c.Products) == 0 {
+		c.Products = d.Products
+	}
+	if c.Start.IsZero() {
+		c.Start = d.Start
+	}
+	if c.End.IsZero() || !c.End.After(c.Start) {
+		c.End = c.Start.Add(d.End.Sub(d.Start))
+	}
+	if c.MaxTransactions <= 0 {
+		c.MaxTransactions = d.MaxTransactions
+	}
+	if c.MinTransactions > c.MaxTransactions {
+		c.MinTransactions = c.MaxTransactions
+	}
+	return c
+}
+
+// Generator generates random receipts. The same seed and constraints
+// result in the same receipts.
+type Generator struct {
+	Constraints Constraints
+	rng         *rand.Rand
+}
+
+// NewGenerator returns a generator of random receipts
+func NewGenerator(seed int64, c Constraints) *Generator {
+	return &Generator{
+		Constraints: c,
+		rng:         rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Receipt returns a random receipt
+func (g *Generator) Receipt() *Receipt {
+	return Generate(g.rng, g.Constraints)
+}
+
+// Values sets random receipts to the arguments of type *Receipt. It can be used as Values of testing/quick.Config.
+func (c Constraints) Values(args []reflect.Value, rng *rand.Rand) {
+	for i := range args {
+		args[i] = reflect.ValueOf(Generate(rng, c))
+	}
+}
+
+// generatedTransaction is a transaction before its ID is assigned
+type generatedTransaction struct {
+	inApp        *InApp
+	purchaseDate time.Time
+	expiresDate  time.Time
+	cancelled    time.Time
+	first        *generatedTransaction
+}
+
+// Generate returns a random receipt which satisfies the constraints.
+// Transaction IDs increase with purchase dates, and renewals of
+// auto-renewable subscriptions share the original transaction of their
+// first purchase.
+func Generate(rng *rand.Rand, c Constraints) *Receipt {
+	c = c.withDefaults()
+
+	start := c.Start.UTC().Truncate(time.Second)
+	end := c.End.UTC().Truncate(time.Second)
+	randomDate := func(from, to time.Time) time.Time {
+		d := to.Sub(from)
+		if d <= 0 {
+			return from
+		}
+		return from.Add(time.Duration(rng.Int63n(int64(d)))).Truncate(time.Second)
+	}
+
+	// The receipt is created after all the purchases and cancellations
+	creationDate := randomDate(start, end)
+
+	n := c.MinTransactions + rng.Intn(c.MaxTransactions-c.MinTransactions+1)
+
+	transactions := []*generatedTransaction{}
+	purchased := map[string]bool{}
+	for attempt := 0; len(transactions) < n && attempt < 10*n; attempt++ {
+		p := c.Products[rng.Intn(len(c.Products))]
+
+		switch p.Type {
+		case ProductTypeNonConsumable:
+			if purchased[p.ProductID] {
+				continue
+			}
+		case ProductTypeAutoRenewable:
+			// A product has one subscription, so that periods do not
+			// overlap
+			if purchased[p.ProductID] || p.AddPeriod == nil {
+				continue
+			}
+		}
+		purchased[p.ProductID] = true
+
+		t := &generatedTransaction{
+			purchaseDate: randomDate(start, creationDate),
+			inApp:        &InApp{ProductID: p.ProductID, Quantity: 1},
+		}
+		t.first = t
+
+		switch p.Type {
+		case ProductTypeConsumable:
+			t.inApp.Quantity = int64(1 + rng.Intn(3))
+		case ProductTypeAutoRenewable:
+			t.expiresDate = p.AddPeriod(t.purchaseDate)
+			switch rng.Intn(4) {
+			case 0:
+				t.inApp.IsTrialPeriod = "true"
+			case 1:
+				t.inApp.IsInIntroOfferPeriod = "true"
+			}
+		}
+
+		transactions = append(transactions, t)
+
+		if p.Type == ProductTypeAutoRenewable {
+			renewals := rng.Intn(n - len(transactions) + 1)
+			last := t
+			for i := 0; i < renewals && !last.expiresDate.After(creationDate); i++ {
+				renewal := &generatedTransaction{
+					purchaseDate: last.expiresDate,
+					expiresDate:  p.AddPeriod(last.expiresDate),
+					inApp:        &InApp{ProductID: p.ProductID, Quantity: 1},
+					first:        t,
+				}
+				transactions = append(transactions, renewal)
+				last = renewal
+			}
+			t = last
+		}
+
+		// Some of the transactions are refunded
+		if rng.Intn(10) == 0 {
+			t.cancelled = randomDate(t.purchaseDate, creationDate)
+			t.inApp.CancellationReason = strconv.Itoa(rng.Intn(2))
+		}
+	}
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].purchaseDate.Before(transactions[j].purchaseDate)
+	})
+
+	transactionID := 1000000000000000 + rng.Int63n(1000000000000)
+	webOrderLineItemID := 1000000000000000 + rng.Int63n(1000000000000)
+
+	r := &Receipt{
+		Version:                    ModelVersion,
+		ReceiptType:                "ProductionSandbox",
+		BundleID:                   c.BundleID,
+		OriginalApplicationVersion: strconv.Itoa(1 + rng.Intn(50)),
+		InApp:                      []*InApp{},
+	}
+	originalVersion, _ := strconv.Atoi(r.OriginalApplicationVersion)
+	r.ApplicationVersion = strconv.Itoa(originalVersion + rng.Intn(50))
+
+	firstPurchase := creationDate
+	for _, t := range transactions {
+		transactionID += 1 + rng.Int63n(1000)
+		t.inApp.TransactionID = strconv.FormatInt(transactionID, 10)
+		t.inApp.OriginalTransactionID = t.first.inApp.TransactionID
+		if t.inApp.IsTrialPeriod == "" {
+			t.inApp.IsTrialPeriod = "false"
+		}
+		if t.inApp.IsInIntroOfferPeriod == "" {
+			t.inApp.IsInIntroOfferPeriod = "false"
+		}
+		if isSubscription(c.Products, t.inApp.ProductID) {
+			webOrderLineItemID += 1 + rng.Int63n(1000)
+			t.inApp.WebOrderLineItemID = webOrderLineItemID
+		}
+
+		t.inApp.PurchaseDate = Date{t.purchaseDate}
+		t.inApp.OriginalPurchaseDate = Date{t.first.purchaseDate}
+		t.inApp.ExpiresDate = Date{t.expiresDate}
+		t.inApp.CancellationDate = Date{t.cancelled}
+
+		if t.purchaseDate.Before(firstPurchase) {
+			firstPurchase = t.purchaseDate
+		}
+
+		r.InApp = append(r.InApp, t.inApp)
+	}
+
+	// The app is downloaded before the first purchase
+	originalPurchaseDate := randomDate(start, firstPurchase)
+
+	r.OriginalPurchaseDate = Date{originalPurchaseDate}
+	r.ReceiptCreationDate = Date{creationDate}
+	r.RequestDate = Date{creationDate}
+
+	return r
+}
+
+// isSubscription reports whether a product is auto-renewable
+func isSubscription(products []GeneratorProduct, productID string) bool {
+	for _, p := range products {
+		if p.ProductID == productID {
+			return p.Type == ProductTypeAutoRenewable
+		}
+	}
+	return false
+}
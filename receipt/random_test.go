@@ -0,0 +1,107 @@
+package receipt
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/aktsk/nolmandy/receipt"
+)
+
+func TestGenerator(t *testing.T) {
+	c := Constraints{BundleID: "jp.aktsk.kalvados.test", MinTransactions: 5, MaxTransactions: 20}
+
+	g1 := NewGenerator(1, c)
+	g2 := NewGenerator(1, c)
+
+	for i := 0; i < 10; i++ {
+		r1, err := json.Marshal(NewResponse(g1.Receipt(), time.Time{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r2, err := json.Marshal(NewResponse(g2.Receipt(), time.Time{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(r1) != string(r2) {
+			t.Fatalf("Receipts should be the same with the same seed:\n%s\n%s", r1, r2)
+		}
+	}
+}
+
+func TestGenerateProperties(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	c := DefaultConstraints()
+
+	consistent := func(r *Receipt) bool {
+		if len(r.InApp) < c.MinTransactions || len(r.InApp) > c.MaxTransactions {
+			t.Logf("Wrong number of transactions: %d", len(r.InApp))
+			return false
+		}
+
+		originalPurchaseDate := r.OriginalPurchaseDate.Time
+		creationDate := r.ReceiptCreationDate.Time
+		if originalPurchaseDate.Before(c.Start) || creationDate.After(c.End) {
+			t.Logf("Dates are out of range: %v %v", originalPurchaseDate, creationDate)
+			return false
+		}
+
+		transactions := map[string]*InApp{}
+		var lastID int64
+		for _, inApp := range r.InApp {
+			id, err := strconv.ParseInt(inApp.TransactionID, 10, 64)
+			if err != nil || id <= lastID {
+				t.Logf("Transaction IDs should increase: %s", inApp.TransactionID)
+				return false
+			}
+			lastID = id
+			transactions[inApp.TransactionID] = inApp
+
+			purchaseDate := inApp.PurchaseDate.Time
+			if purchaseDate.Before(originalPurchaseDate) || purchaseDate.After(creationDate) {
+				t.Logf("purchase_date is out of range: %v", purchaseDate)
+				return false
+			}
+
+			original, ok := transactions[inApp.OriginalTransactionID]
+			if !ok || original.ProductID != inApp.ProductID {
+				t.Logf("Wrong original_transaction_id: %s", inApp.OriginalTransactionID)
+				return false
+			}
+
+			expiresDate := inApp.ExpiresDate.Time
+			if !expiresDate.IsZero() && !expiresDate.After(purchaseDate) {
+				t.Logf("expires_date should be after purchase_date: %v", expiresDate)
+				return false
+			}
+
+			cancellationDate := inApp.CancellationDate.Time
+			if !cancellationDate.IsZero() && (cancellationDate.Before(purchaseDate) || cancellationDate.After(creationDate)) {
+				t.Logf("cancellation_date is out of range: %v", cancellationDate)
+				return false
+			}
+		}
+
+		rcpt, err := r.Encode(privKey, cert)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+
+		parsed, err := receipt.Parse(cert, rcpt)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+
+		return len(parsed.InApp) == len(r.InApp)
+	}
+
+	if err := quick.Check(consistent, &quick.Config{MaxCount: 50, Values: c.Values}); err != nil {
+		t.Fatal(err)
+	}
+}
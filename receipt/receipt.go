@@ -2,43 +2,248 @@ package receipt
 
 import (
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/aktsk/nolmandy/receipt"
 	"github.com/fullsailor/pkcs7"
 )
 
-// Encode encodes JSON receipt data
-func Encode(receiptJSON []byte, key *rsa.PrivateKey, cert *x509.Certificate) (string, error) {
-	rcpt := receipt.Receipt{}
-	json.Unmarshal(receiptJSON, &rcpt)
+// Option configures how a receipt is encoded
+type Option func(*options)
+
+type options struct {
+	now        func() time.Time
+	response   *Response
+	apple      bool
+	ber        bool
+	attributes *ReceiptAttributes
+	strict     bool
+	warnings   *[]string
+}
+
+// WithNow sets the function used to get the current time. It is used
+// for receipt_creation_date when the JSON receipt data does not have it.
+func WithNow(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithResponse sets res to the verifyReceipt style response which the
+// App Store would return for the encoded receipt
+func WithResponse(res *Response) Option {
+	return func(o *options) {
+		o.response = res
+	}
+}
+
+// WithAppleEncoding sets whether the receipt is encoded in the ASN.1
+// structure of the App Store, which is a SET of attributes with
+// UTF8String values and IA5String dates, and always has opaque_value and
+// sha1_hash. It is enabled by default.
+// Disabling it encodes receipts as the earlier versions of kalvados did,
+// with a SEQUENCE of attributes and PrintableString values.
+func WithAppleEncoding(enabled bool) Option {
+	return func(o *options) {
+		o.apple = enabled
+	}
+}
+
+// WithIndefiniteLength sets whether the PKCS#7 container is encoded in
+// BER with indefinite lengths like receipts of the App Store, instead of
+// DER. It is disabled by default.
+func WithIndefiniteLength(enabled bool) Option {
+	return func(o *options) {
+		o.ber = enabled
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{now: time.Now, apple: true}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Encode encodes JSON receipt data. Its extra_attributes,
+// attribute_overrides and attribute_order change the encoded attributes.
+func Encode(receiptJSON []byte, key *rsa.PrivateKey, cert *x509.Certificate, opts ...Option) (string, error) {
+	if o := newOptions(opts); o.strict || o.warnings != nil {
+		warnings, err := CheckFields(receiptJSON, opts...)
+		if o.warnings != nil {
+			*o.warnings = warnings
+		}
+		if err != nil && o.strict {
+			return "", err
+		}
+	}
+
+	rcpt, err := UnmarshalReceipt(receiptJSON)
+	if err != nil {
+		return "", err
+	}
+
+	attributes, err := UnmarshalAttributes(receiptJSON)
+	if err != nil {
+		return "", err
+	}
+
+	opts = append([]Option{WithAttributes(attributes)}, opts...)
+	return rcpt.Encode(key, cert, opts...)
+}
+
+// Unmarshal parses JSON receipt data into nolmandy's receipt
+func Unmarshal(receiptJSON []byte) (*receipt.Receipt, error) {
+	rcpt, err := UnmarshalReceipt(receiptJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return rcpt.Nolmandy()
+}
+
+// EncodeReceipt encodes nolmandy's receipt. It is kept for
+// compatibility, and Receipt.Encode also encodes the fields nolmandy's
+// receipt does not have.
+func EncodeReceipt(rcpt *receipt.Receipt, key *rsa.PrivateKey, cert *x509.Certificate, opts ...Option) (string, error) {
+	return FromNolmandy(rcpt).Encode(key, cert, opts...)
+}
 
-	payload, _ := encodeReceipt(rcpt)
+// Encode encodes the receipt. receipt_creation_date is set to the
+// current time if it is empty.
+func (r *Receipt) Encode(key *rsa.PrivateKey, cert *x509.Certificate, opts ...Option) (string, error) {
+	o := newOptions(opts)
 
-	signed, _ := signReceipt(payload, key, cert)
+	if r.ReceiptCreationDate.IsZero() {
+		r.ReceiptCreationDate = Date{o.now().UTC().Truncate(time.Second)}
+	}
+
+	encodedReceipt, err := encode(r, key, cert, o)
+	if err != nil {
+		return "", err
+	}
 
-	encodedReceipt := base64.StdEncoding.EncodeToString(signed)
+	if o.response != nil {
+		*o.response = *NewVerifyResponse(r, encodedReceipt, o.now())
+	}
 
 	return encodedReceipt, nil
 }
 
+func encode(r *Receipt, key *rsa.PrivateKey, cert *x509.Certificate, o *options) (string, error) {
+	payload, err := encodeReceipt(r, encoder{apple: o.apple}, o.attributes)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signReceipt(payload, key, cert)
+	if err != nil {
+		return "", err
+	}
+
+	if o.ber {
+		if signed, err = indefiniteLength(signed); err != nil {
+			return "", err
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(signed), nil
+}
+
+// SetDate sets t to a date field of nolmandy's receipt like
+// &inApp.CancellationDate.Date. The type of these fields is not
+// exported, so they are set through their JSON form.
+func SetDate(d json.Unmarshaler, t time.Time) error {
+	s := t.UTC().Format("2006-01-02 15:04:05 Etc/GMT")
+	return d.UnmarshalJSON([]byte(strconv.Quote(s)))
+}
+
 type attribute struct {
 	Type    int
 	Version int
 	Value   []byte
 }
 
-func encodeReceipt(r receipt.Receipt) ([]byte, error) {
+// encoder encodes the values of receipt attributes
+type encoder struct {
+	apple bool
+}
+
+func (e encoder) string(s string) ([]byte, error) {
+	if e.apple {
+		return asn1.MarshalWithParams(s, "utf8")
+	}
+	return asn1.Marshal(s)
+}
+
+// date encodes t as an RFC 3339 date. The App Store encodes missing
+// dates as empty strings.
+func (e encoder) date(t time.Time) ([]byte, error) {
+	if !e.apple {
+		return asn1.Marshal(t.Format(time.RFC3339))
+	}
+	if t.IsZero() {
+		return asn1.MarshalWithParams("", "ia5")
+	}
+	return asn1.MarshalWithParams(t.UTC().Format(time.RFC3339), "ia5")
+}
+
+// attributes encodes the attributes changed by a. The attributes of a
+// SET are sorted unless a has an order.
+func (e encoder) attributes(payload []attribute, a *Attributes) ([]byte, error) {
+	payload, err := a.apply(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.apple {
+		return asn1.Marshal(payload)
+	}
+
+	if !a.ordered() {
+		return asn1.MarshalWithParams(payload, "set")
+	}
+
+	// asn1 sorts the elements of a SET, so it is built from the encoded
+	// attributes
+	set := asn1.RawValue{Tag: asn1.TagSet, IsCompound: true}
+	for _, ra := range payload {
+		encoded, err := asn1.Marshal(ra)
+		if err != nil {
+			return nil, err
+		}
+		set.Bytes = append(set.Bytes, encoded...)
+	}
+
+	return asn1.Marshal(set)
+}
+
+// placeholderOpaqueValueLength is the length of the opaque value encoded
+// for receipts without one
+const placeholderOpaqueValueLength = 16
+
+// placeholderSHA1Hash returns the SHA-1 hash encoded for receipts without
+// one. The App Store hashes the device identifier, the opaque value and
+// the encoded bundle ID, and the device identifier is taken as empty.
+func placeholderSHA1Hash(opaqueValue, bundleID []byte) []byte {
+	sum := sha1.Sum(append(append([]byte{}, opaqueValue...), bundleID...))
+	return sum[:]
+}
+
+func encodeReceipt(r *Receipt, e encoder, a *ReceiptAttributes) ([]byte, error) {
 	payload := []attribute{}
 
 	var ra attribute
 
 	// 0: receipt_type
-	receiptType, err := asn1.Marshal(r.ReceiptType)
+	receiptType, err := e.string(r.ReceiptType)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +252,7 @@ func encodeReceipt(r receipt.Receipt) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 2: bundle_id
-	bundleID, err := asn1.Marshal(r.BundleID)
+	bundleID, err := e.string(r.BundleID)
 	if err != nil {
 		return nil, err
 	}
@@ -55,9 +260,45 @@ func encodeReceipt(r receipt.Receipt) ([]byte, error) {
 	ra.Value = bundleID
 	payload = append(payload, ra)
 
+	// 3: application_version
+	if e.apple {
+		applicationVersion, err := e.string(r.ApplicationVersion)
+		if err != nil {
+			return nil, err
+		}
+		ra.Type = 3
+		ra.Value = applicationVersion
+		payload = append(payload, ra)
+	}
+
+	var attributes *Attributes
+	if a != nil {
+		attributes = &a.Attributes
+	}
+
+	// 4: opaque_value and 5: sha1_hash. Receipts issued by the App
+	// Store always have them, so placeholders are encoded in the
+	// structure of the App Store unless they are extra attributes.
+	opaqueValue, sha1Hash := r.OpaqueValue, r.SHA1Hash
+	if e.apple && len(opaqueValue) == 0 && !attributes.hasExtra(4) {
+		opaqueValue = make([]byte, placeholderOpaqueValueLength)
+	}
+	if e.apple && len(sha1Hash) == 0 && !attributes.hasExtra(5) {
+		sha1Hash = placeholderSHA1Hash(opaqueValue, bundleID)
+	}
+	if len(opaqueValue) > 0 {
+		ra.Type = 4
+		ra.Value = opaqueValue
+		payload = append(payload, ra)
+	}
+	if len(sha1Hash) > 0 {
+		ra.Type = 5
+		ra.Value = sha1Hash
+		payload = append(payload, ra)
+	}
+
 	// 12: receipt_creation_date
-	t := time.Time(r.CreationDate.Date)
-	creationDate, err := asn1.Marshal(t.Format(time.RFC3339))
+	creationDate, err := e.date(r.ReceiptCreationDate.Time)
 	if err != nil {
 		return nil, err
 	}
@@ -66,8 +307,8 @@ func encodeReceipt(r receipt.Receipt) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 17: in_app
-	for _, inApp := range r.InApp {
-		encodedInApp, err := encodeInApp(inApp)
+	for i, inApp := range r.InApp {
+		encodedInApp, err := encodeInApp(inApp, e, a.inApp(i))
 		if err != nil {
 			return nil, err
 		}
@@ -77,8 +318,7 @@ func encodeReceipt(r receipt.Receipt) ([]byte, error) {
 	}
 
 	// 18: original_purchase_date
-	t = time.Time(r.OriginalPurchaseDate.Date)
-	originalPurchaseDate, err := asn1.Marshal(t.Format(time.RFC3339))
+	originalPurchaseDate, err := e.date(r.OriginalPurchaseDate.Time)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +327,7 @@ func encodeReceipt(r receipt.Receipt) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 19: original_application_version
-	originalApplicationVersion, err := asn1.Marshal(r.OriginalApplicationVersion)
+	originalApplicationVersion, err := e.string(r.OriginalApplicationVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -96,8 +336,7 @@ func encodeReceipt(r receipt.Receipt) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 21: expiration_date
-	t = time.Time(r.ExpirationDate)
-	expirationDate, err := asn1.Marshal(t.Format(time.RFC3339))
+	expirationDate, err := e.date(r.ExpirationDate.Time)
 	if err != nil {
 		return nil, err
 	}
@@ -105,11 +344,11 @@ func encodeReceipt(r receipt.Receipt) ([]byte, error) {
 	ra.Value = expirationDate
 	payload = append(payload, ra)
 
-	data, err := asn1.Marshal(payload)
+	data, err := e.attributes(payload, attributes)
 	return data, err
 }
 
-func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
+func encodeInApp(inApp *InApp, e encoder, a *Attributes) ([]byte, error) {
 	payload := []attribute{}
 
 	var ra attribute
@@ -124,7 +363,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 1702: product_id
-	productID, err := asn1.Marshal(inApp.ProductID)
+	productID, err := e.string(inApp.ProductID)
 	if err != nil {
 		return nil, err
 	}
@@ -133,7 +372,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 1703: transaction_id
-	transactionID, err := asn1.Marshal(inApp.TransactionID)
+	transactionID, err := e.string(inApp.TransactionID)
 	if err != nil {
 		return nil, err
 	}
@@ -142,8 +381,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 1704: purchase_date
-	t := time.Time(inApp.PurchaseDate.Date)
-	purchaseDate, err := asn1.Marshal(t.Format(time.RFC3339))
+	purchaseDate, err := e.date(inApp.PurchaseDate.Time)
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +390,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 1705: original_transaction_id
-	originalTransactionID, err := asn1.Marshal(inApp.OriginalTransactionID)
+	originalTransactionID, err := e.string(inApp.OriginalTransactionID)
 	if err != nil {
 		return nil, err
 	}
@@ -161,8 +399,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 1706: original_purachase_date
-	t = time.Time(inApp.OriginalPurchaseDate.Date)
-	originalPurchaseDate, err := asn1.Marshal(t.Format(time.RFC3339))
+	originalPurchaseDate, err := e.date(inApp.OriginalPurchaseDate.Time)
 	if err != nil {
 		return nil, err
 	}
@@ -171,8 +408,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 1708: expires_date
-	t = time.Time(inApp.ExpiresDate.Date)
-	expiresDate, err := asn1.Marshal(t.Format(time.RFC3339))
+	expiresDate, err := e.date(inApp.ExpiresDate.Time)
 	if err != nil {
 		return nil, err
 	}
@@ -190,8 +426,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// 1712: cancellation_date
-	t = time.Time(inApp.CancellationDate.Date)
-	cancellationDate, err := asn1.Marshal(t.Format(time.RFC3339))
+	cancellationDate, err := e.date(inApp.CancellationDate.Time)
 	if err != nil {
 		return nil, err
 	}
@@ -201,7 +436,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 
 	// 1719: is_intro_price
 	var isIntroPrice []byte
-	if inApp.IsInIntroPrice == true {
+	if inApp.IsInIntroOfferPeriod == "true" {
 		isIntroPrice, _ = asn1.Marshal(1)
 	} else {
 		isIntroPrice, _ = asn1.Marshal(0)
@@ -211,7 +446,7 @@ func encodeInApp(inApp *receipt.InApp) ([]byte, error) {
 	payload = append(payload, ra)
 
 	// All of InApp
-	data, err := asn1.Marshal(payload)
+	data, err := e.attributes(payload, a)
 	return data, err
 }
 
@@ -1,15 +1,26 @@
 package receipt
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
+	"os"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aktsk/nolmandy/receipt"
+	"github.com/fullsailor/pkcs7"
 )
 
 func TestReceipt(t *testing.T) {
@@ -85,6 +96,340 @@ func TestReceipt(t *testing.T) {
 
 }
 
+// TestAppleEncoding compares the ASN.1 types of the encoded receipt with
+// the ones of receipts issued by the App Store
+func TestAppleEncoding(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	expected, err := ioutil.ReadFile("testdata/apple_structure.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt, err := Encode([]byte(receiptJSON), privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	structure, err := dumpStructure(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if structure != stripComments(string(expected)) {
+		t.Fatalf("Wrong structure:\n%s\nexpected:\n%s", structure, expected)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSetOrder(p7.Content); err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt, err = Encode([]byte(receiptJSON), privKey, cert, WithAppleEncoding(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	structure, err = dumpStructure(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix([]byte(structure), []byte("SEQUENCE\n  0 PrintableString\n")) {
+		t.Fatalf("Wrong structure without Apple encoding:\n%s", structure)
+	}
+
+	if _, err := receipt.Parse(cert, rcpt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAppleEncodingMatchesSandboxReceipt compares the tag of each
+// attribute kalvados encodes with the one of the same type in receipt
+// data issued by the App Store sandbox, in
+// testdata/sandbox_receipt.txt. Attributes kalvados does not encode are
+// not compared.
+func TestAppleEncodingMatchesSandboxReceipt(t *testing.T) {
+	sandboxReceipt, err := ioutil.ReadFile("testdata/sandbox_receipt.txt")
+	if os.IsNotExist(err) {
+		t.Skip("testdata/sandbox_receipt.txt does not exist")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Inspect(strings.TrimSpace(string(sandboxReceipt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKey, cert := generateKeyAndCert()
+
+	rcpt, err := Encode([]byte(receiptJSON), privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := Inspect(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual.Payload != expected.Payload {
+		t.Fatalf("Wrong payload: %s, expected %s", actual.Payload, expected.Payload)
+	}
+
+	expectedTags, expectedInAppTags := attributeTags(expected.Attributes)
+	actualTags, actualInAppTags := attributeTags(actual.Attributes)
+
+	for _, tags := range []struct {
+		name             string
+		actual, expected map[int]string
+	}{
+		{"receipt", actualTags, expectedTags},
+		{"in_app", actualInAppTags, expectedInAppTags},
+	} {
+		for typ, tag := range tags.actual {
+			expectedTag, ok := tags.expected[typ]
+			if !ok {
+				t.Logf("%s %d is not in the sandbox receipt", tags.name, typ)
+				continue
+			}
+			if tag != expectedTag {
+				t.Errorf("Wrong tag of %s %d: %s, expected %s", tags.name, typ, tag, expectedTag)
+			}
+		}
+	}
+}
+
+// attributeTags returns the tags of the attributes of a receipt and of
+// its in-app purchase receipts by type
+func attributeTags(attributes []*AttributeReport) (map[int]string, map[int]string) {
+	tags := map[int]string{}
+	inAppTags := map[int]string{}
+
+	for _, a := range attributes {
+		tags[a.Type] = a.Tag
+		for _, ia := range a.InApp {
+			inAppTags[ia.Type] = ia.Tag
+		}
+	}
+
+	return tags, inAppTags
+}
+
+func TestIndefiniteLength(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	rcpt, err := Encode([]byte(receiptJSON), privKey, cert, WithIndefiniteLength(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(data, []byte{0x30, 0x80}) {
+		t.Fatalf("ContentInfo should have an indefinite length: % x", data[:2])
+	}
+
+	if !bytes.HasSuffix(data, []byte{0, 0}) {
+		t.Fatal("ContentInfo should end with end-of-contents")
+	}
+
+	if !bytes.Contains(data, []byte{0x24, 0x80, 0x04}) {
+		t.Fatal("Content should be a constructed OCTET STRING")
+	}
+
+	parsed, err := receipt.Parse(cert, rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.BundleID != "jp.aktsk.kalvados.test" {
+		t.Fatalf("Wrong bundle_id: %s", parsed.BundleID)
+	}
+
+	if len(parsed.InApp) != 3 {
+		t.Fatalf("Wrong number of in_app: %d", len(parsed.InApp))
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	var r map[string]interface{}
+	if err := json.Unmarshal([]byte(receiptJSON), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	r["extra_attributes"] = []interface{}{
+		map[string]interface{}{"type": 1234, "utf8_string": "unknown"},
+		map[string]interface{}{"type": 2, "utf8_string": "jp.aktsk.kalvados.duplicate"},
+	}
+	r["attribute_overrides"] = []interface{}{
+		map[string]interface{}{"type": 19, "version": 2},
+		map[string]interface{}{"type": 12, "remove": true},
+	}
+	r["attribute_order"] = []int{19, 2}
+	r["in_app"].([]interface{})[0].(map[string]interface{})["extra_attributes"] = []interface{}{
+		map[string]interface{}{"type": 1707, "integer": 1},
+	}
+
+	receiptJSON, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt, err := Encode(receiptJSON, privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := decodeAttributes(p7.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attributes[0].Type != 19 || attributes[0].Version != 2 {
+		t.Fatalf("original_application_version should be the first with version 2: %d %d", attributes[0].Type, attributes[0].Version)
+	}
+
+	if attributes[1].Type != 2 || attributes[2].Type != 2 {
+		t.Fatalf("bundle_id should be duplicated: %d %d", attributes[1].Type, attributes[2].Type)
+	}
+
+	types := map[int]bool{}
+	for _, ra := range attributes {
+		types[ra.Type] = true
+	}
+
+	if types[12] {
+		t.Fatal("receipt_creation_date should be removed")
+	}
+
+	if !types[1234] {
+		t.Fatal("Unknown attribute should be added")
+	}
+
+	for _, ra := range attributes {
+		if ra.Type != 17 {
+			continue
+		}
+		inApp, err := decodeAttributes(ra.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, ia := range inApp {
+			found = found || ia.Type == 1707
+		}
+		if !found {
+			t.Fatal("Unknown in-app attribute should be added")
+		}
+		break
+	}
+
+	if _, err := receipt.Parse(cert, rcpt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dumpStructure returns the ASN.1 types of the attributes of an encoded
+// receipt. Attributes are sorted by type, as the order of a SET depends
+// on the encoded values. It is checked by checkSetOrder.
+func dumpStructure(rcpt string) (string, error) {
+	report, err := Inspect(rcpt)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, report.Payload)
+	dumpAttributes(&b, report.Attributes, "")
+
+	return b.String(), nil
+}
+
+func dumpAttributes(w io.Writer, attributes []*AttributeReport, indent string) {
+	sorted := append([]*AttributeReport{}, attributes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Type < sorted[j].Type
+	})
+
+	for _, a := range sorted {
+		fmt.Fprintf(w, "%s  %d %s\n", indent, a.Type, a.Tag)
+		dumpAttributes(w, a.InApp, indent+"  ")
+	}
+}
+
+// stripComments removes lines starting with "#"
+func stripComments(s string) string {
+	lines := []string{}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// checkSetOrder returns an error if the attributes of a SET are not in
+// the order of their encodings, which DER requires for SET OF
+func checkSetOrder(data []byte) error {
+	var set asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &set); err != nil {
+		return err
+	}
+
+	var previous []byte
+	for rest := set.Bytes; len(rest) > 0; {
+		var element asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &element); err != nil {
+			return err
+		}
+
+		if bytes.Compare(previous, element.FullBytes) > 0 {
+			return fmt.Errorf("attribute %x is after %x", previous, element.FullBytes)
+		}
+		previous = element.FullBytes
+
+		var ra attribute
+		if _, err := asn1.Unmarshal(element.FullBytes, &ra); err != nil {
+			return err
+		}
+		if ra.Type == 17 {
+			if err := checkSetOrder(ra.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func generateKeyAndCert() (*rsa.PrivateKey, *x509.Certificate) {
 	privKey, _ := rsa.GenerateKey(rand.Reader, 1024)
 
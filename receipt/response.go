@@ -0,0 +1,270 @@
+package receipt
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Response is a verifyReceipt style response
+// https://developer.apple.com/documentation/appstorereceipts/responsebody
+type Response struct {
+	Status             int                   `json:"status"`
+	Environment        string                `json:"environment"`
+	Receipt            *ResponseReceipt      `json:"receipt"`
+	LatestReceipt      string                `json:"latest_receipt,omitempty"`
+	LatestReceiptInfo  []*ResponseInApp      `json:"latest_receipt_info,omitempty"`
+	PendingRenewalInfo []*PendingRenewalInfo `json:"pending_renewal_info,omitempty"`
+}
+
+// ResponseReceipt is the receipt in a verifyReceipt style response
+type ResponseReceipt struct {
+	ReceiptType                string           `json:"receipt_type"`
+	AdamID                     int64            `json:"adam_id"`
+	AppItemID                  int64            `json:"app_item_id"`
+	BundleID                   string           `json:"bundle_id"`
+	ApplicationVersion         string           `json:"application_version"`
+	DownloadID                 int64            `json:"download_id"`
+	VersionExternalIdentifier  int64            `json:"version_external_identifier"`
+	ReceiptCreationDate        string           `json:"receipt_creation_date"`
+	ReceiptCreationDateMS      string           `json:"receipt_creation_date_ms"`
+	ReceiptCreationDatePST     string           `json:"receipt_creation_date_pst"`
+	RequestDate                string           `json:"request_date"`
+	RequestDateMS              string           `json:"request_date_ms"`
+	RequestDatePST             string           `json:"request_date_pst"`
+	OriginalPurchaseDate       string           `json:"original_purchase_date"`
+	OriginalPurchaseDateMS     string           `json:"original_purchase_date_ms"`
+	OriginalPurchaseDatePST    string           `json:"original_purchase_date_pst"`
+	OriginalApplicationVersion string           `json:"original_application_version"`
+	ExpirationDate             string           `json:"expiration_date,omitempty"`
+	ExpirationDateMS           string           `json:"expiration_date_ms,omitempty"`
+	ExpirationDatePST          string           `json:"expiration_date_pst,omitempty"`
+	PreorderDate               string           `json:"preorder_date,omitempty"`
+	PreorderDateMS             string           `json:"preorder_date_ms,omitempty"`
+	PreorderDatePST            string           `json:"preorder_date_pst,omitempty"`
+	InApp                      []*ResponseInApp `json:"in_app"`
+}
+
+// ResponseInApp is an in-app purchase transaction in a verifyReceipt
+// style response
+type ResponseInApp struct {
+	Quantity                    string `json:"quantity"`
+	ProductID                   string `json:"product_id"`
+	TransactionID               string `json:"transaction_id"`
+	OriginalTransactionID       string `json:"original_transaction_id"`
+	PurchaseDate                string `json:"purchase_date"`
+	PurchaseDateMS              string `json:"purchase_date_ms"`
+	PurchaseDatePST             string `json:"purchase_date_pst"`
+	OriginalPurchaseDate        string `json:"original_purchase_date"`
+	OriginalPurchaseDateMS      string `json:"original_purchase_date_ms"`
+	OriginalPurchaseDatePST     string `json:"original_purchase_date_pst"`
+	ExpiresDate                 string `json:"expires_date,omitempty"`
+	ExpiresDateMS               string `json:"expires_date_ms,omitempty"`
+	ExpiresDatePST              string `json:"expires_date_pst,omitempty"`
+	WebOrderLineItemID          string `json:"web_order_line_item_id,omitempty"`
+	IsTrialPeriod               string `json:"is_trial_period"`
+	IsInIntroOfferPeriod        string `json:"is_in_intro_offer_period,omitempty"`
+	IsUpgraded                  string `json:"is_upgraded,omitempty"`
+	CancellationDate            string `json:"cancellation_date,omitempty"`
+	CancellationDateMS          string `json:"cancellation_date_ms,omitempty"`
+	CancellationDatePST         string `json:"cancellation_date_pst,omitempty"`
+	CancellationReason          string `json:"cancellation_reason,omitempty"`
+	SubscriptionGroupIdentifier string `json:"subscription_group_identifier,omitempty"`
+	PromotionalOfferID          string `json:"promotional_offer_id,omitempty"`
+	OfferCodeRefName            string `json:"offer_code_ref_name,omitempty"`
+	InAppOwnershipType          string `json:"in_app_ownership_type,omitempty"`
+}
+
+// PendingRenewalInfo is the renewal information of an auto-renewable
+// subscription in a verifyReceipt style response
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/pending_renewal_info
+type PendingRenewalInfo struct {
+	AutoRenewProductID        string `json:"auto_renew_product_id"`
+	AutoRenewStatus           string `json:"auto_renew_status"`
+	ExpirationIntent          string `json:"expiration_intent,omitempty"`
+	GracePeriodExpiresDate    string `json:"grace_period_expires_date,omitempty"`
+	GracePeriodExpiresDateMS  string `json:"grace_period_expires_date_ms,omitempty"`
+	GracePeriodExpiresDatePST string `json:"grace_period_expires_date_pst,omitempty"`
+	IsInBillingRetryPeriod    string `json:"is_in_billing_retry_period,omitempty"`
+	OriginalTransactionID     string `json:"original_transaction_id"`
+	PriceConsentStatus        string `json:"price_consent_status,omitempty"`
+	ProductID                 string `json:"product_id"`
+	PromotionalOfferID        string `json:"promotional_offer_id,omitempty"`
+	OfferCodeRefName          string `json:"offer_code_ref_name,omitempty"`
+}
+
+// NewResponse returns a verifyReceipt style response for a receipt
+func NewResponse(r *Receipt, requestDate time.Time) *Response {
+	rr := &ResponseReceipt{
+		ReceiptType:                r.ReceiptType,
+		AdamID:                     r.AdamID,
+		AppItemID:                  r.AppItemID,
+		BundleID:                   r.BundleID,
+		ApplicationVersion:         r.ApplicationVersion,
+		DownloadID:                 r.DownloadID,
+		VersionExternalIdentifier:  r.VersionExternalIdentifier,
+		OriginalApplicationVersion: r.OriginalApplicationVersion,
+		InApp:                      []*ResponseInApp{},
+	}
+
+	rr.ReceiptCreationDate, rr.ReceiptCreationDateMS, rr.ReceiptCreationDatePST = FormatDate(r.ReceiptCreationDate.Time)
+	rr.RequestDate, rr.RequestDateMS, rr.RequestDatePST = FormatDate(requestDate)
+	rr.OriginalPurchaseDate, rr.OriginalPurchaseDateMS, rr.OriginalPurchaseDatePST = FormatDate(r.OriginalPurchaseDate.Time)
+	rr.ExpirationDate, rr.ExpirationDateMS, rr.ExpirationDatePST = FormatDate(r.ExpirationDate.Time)
+	rr.PreorderDate, rr.PreorderDateMS, rr.PreorderDatePST = FormatDate(r.PreorderDate.Time)
+
+	for _, inApp := range r.InApp {
+		rr.InApp = append(rr.InApp, NewResponseInApp(inApp))
+	}
+
+	return &Response{
+		Status:      0,
+		Environment: environment(r.ReceiptType),
+		Receipt:     rr,
+	}
+}
+
+// NewVerifyResponse returns the verifyReceipt style response which the
+// App Store would return for a receipt and its base64 encoded form.
+// latest_receipt_info and pending_renewal_info are derived from the
+// transactions of the receipt if it has auto-renewable subscriptions.
+func NewVerifyResponse(r *Receipt, latestReceipt string, requestDate time.Time) *Response {
+	res := NewResponse(r, requestDate)
+	res.LatestReceipt = latestReceipt
+
+	subscriptions := map[string]*InApp{}
+	for _, inApp := range r.InApp {
+		if inApp.ExpiresDate.IsZero() {
+			continue
+		}
+		latest, ok := subscriptions[inApp.OriginalTransactionID]
+		if !ok || inApp.ExpiresDate.After(latest.ExpiresDate.Time) {
+			subscriptions[inApp.OriginalTransactionID] = inApp
+		}
+	}
+
+	if len(subscriptions) == 0 {
+		return res
+	}
+
+	// latest_receipt_info is sorted by purchase_date, the newest first
+	inApps := append([]*InApp{}, r.InApp...)
+	sort.SliceStable(inApps, func(i, j int) bool {
+		pi, pj := inApps[i].PurchaseDate, inApps[j].PurchaseDate
+		if pi.Equal(pj.Time) {
+			return LessTransactionID(inApps[j].TransactionID, inApps[i].TransactionID)
+		}
+		return pi.After(pj.Time)
+	})
+	for _, inApp := range inApps {
+		res.LatestReceiptInfo = append(res.LatestReceiptInfo, NewResponseInApp(inApp))
+	}
+
+	ids := []string{}
+	for id := range subscriptions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		res.PendingRenewalInfo = append(res.PendingRenewalInfo, newPendingRenewalInfo(subscriptions[id], requestDate))
+	}
+
+	return res
+}
+
+// newPendingRenewalInfo returns the renewal information of a
+// subscription derived from its latest transaction. A subscription
+// which has expired or been cancelled is not renewed. Why it has
+// expired, by the customer or by a billing error, is not in the receipt,
+// so expiration_intent is omitted.
+func newPendingRenewalInfo(latest *InApp, now time.Time) *PendingRenewalInfo {
+	info := &PendingRenewalInfo{
+		AutoRenewProductID:    latest.ProductID,
+		AutoRenewStatus:       "1",
+		OriginalTransactionID: latest.OriginalTransactionID,
+		ProductID:             latest.ProductID,
+	}
+
+	if !latest.CancellationDate.IsZero() || !now.Before(latest.ExpiresDate.Time) {
+		info.AutoRenewStatus = "0"
+		info.IsInBillingRetryPeriod = "0"
+	}
+
+	return info
+}
+
+// NewResponseInApp returns an in-app purchase transaction in a
+// verifyReceipt style response
+func NewResponseInApp(inApp *InApp) *ResponseInApp {
+	ri := &ResponseInApp{
+		Quantity:                    strconv.FormatInt(inApp.Quantity, 10),
+		ProductID:                   inApp.ProductID,
+		TransactionID:               inApp.TransactionID,
+		OriginalTransactionID:       inApp.OriginalTransactionID,
+		IsTrialPeriod:               inApp.IsTrialPeriod,
+		IsUpgraded:                  inApp.IsUpgraded,
+		CancellationReason:          inApp.CancellationReason,
+		SubscriptionGroupIdentifier: inApp.SubscriptionGroupIdentifier,
+		PromotionalOfferID:          inApp.PromotionalOfferID,
+		OfferCodeRefName:            inApp.OfferCodeRefName,
+		InAppOwnershipType:          inApp.InAppOwnershipType,
+	}
+
+	if ri.IsTrialPeriod == "" {
+		ri.IsTrialPeriod = "false"
+	}
+
+	if inApp.WebOrderLineItemID != 0 {
+		ri.WebOrderLineItemID = strconv.FormatInt(inApp.WebOrderLineItemID, 10)
+	}
+
+	ri.PurchaseDate, ri.PurchaseDateMS, ri.PurchaseDatePST = FormatDate(inApp.PurchaseDate.Time)
+	ri.OriginalPurchaseDate, ri.OriginalPurchaseDateMS, ri.OriginalPurchaseDatePST = FormatDate(inApp.OriginalPurchaseDate.Time)
+	ri.ExpiresDate, ri.ExpiresDateMS, ri.ExpiresDatePST = FormatDate(inApp.ExpiresDate.Time)
+	ri.CancellationDate, ri.CancellationDateMS, ri.CancellationDatePST = FormatDate(inApp.CancellationDate.Time)
+
+	if ri.ExpiresDate != "" {
+		ri.IsInIntroOfferPeriod = inApp.IsInIntroOfferPeriod
+		if ri.IsInIntroOfferPeriod == "" {
+			ri.IsInIntroOfferPeriod = "false"
+		}
+	}
+
+	return ri
+}
+
+// LessTransactionID returns whether transaction ID a is less than b in
+// numeric order. IDs issued later are greater.
+func LessTransactionID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// FormatDate formats t in the three forms used by verifyReceipt
+// responses. It returns empty strings for the zero time.
+func FormatDate(t time.Time) (date, ms, pst string) {
+	if t.IsZero() {
+		return "", "", ""
+	}
+
+	date = t.UTC().Format("2006-01-02 15:04:05 Etc/GMT")
+	ms = strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		loc = time.FixedZone("PST", -8*60*60)
+	}
+	pst = t.In(loc).Format("2006-01-02 15:04:05 America/Los_Angeles")
+
+	return date, ms, pst
+}
+
+func environment(receiptType string) string {
+	if receiptType == "Production" {
+		return "Production"
+	}
+	return "Sandbox"
+}
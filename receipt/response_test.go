@@ -0,0 +1,121 @@
+package receipt
+
+import (
+	"testing"
+	"time"
+)
+
+var subscriptionReceiptJSON = `
+{
+  "receipt_type": "ProductionSandbox",
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "application_version": "1",
+  "original_application_version": "1.0",
+  "in_app": [
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000001",
+      "original_transaction_id": "1000000000000001",
+      "web_order_line_item_id": 1000000000000002,
+      "is_trial_period": "false",
+      "purchase_date": "2018-04-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-04-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-05-01 00:00:00 Etc/GMT"
+    },
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000003",
+      "original_transaction_id": "1000000000000001",
+      "web_order_line_item_id": 1000000000000004,
+      "is_trial_period": "false",
+      "purchase_date": "2018-05-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-04-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-06-01 00:00:00 Etc/GMT"
+    }
+  ],
+  "receipt_creation_date": "2018-05-01 00:00:00 Etc/GMT",
+  "original_purchase_date": "2018-04-01 00:00:00 Etc/GMT"
+}`
+
+func TestVerifyResponse(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	now := time.Date(2018, 5, 15, 0, 0, 0, 0, time.UTC)
+
+	var res Response
+	rcpt, err := Encode([]byte(subscriptionReceiptJSON), privKey, cert, WithNow(func() time.Time { return now }), WithResponse(&res))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.LatestReceipt != rcpt {
+		t.Fatal("latest_receipt should be the encoded receipt")
+	}
+
+	if res.Receipt.RequestDateMS != "1526342400000" {
+		t.Fatalf("Wrong request_date_ms: %s", res.Receipt.RequestDateMS)
+	}
+
+	if len(res.LatestReceiptInfo) != 2 || res.LatestReceiptInfo[0].TransactionID != "1000000000000003" {
+		t.Fatalf("latest_receipt_info should be the newest first: %+v", res.LatestReceiptInfo)
+	}
+
+	latest := res.LatestReceiptInfo[0]
+	if latest.ExpiresDate != "2018-06-01 00:00:00 Etc/GMT" || latest.ExpiresDateMS != "1527811200000" || latest.ExpiresDatePST != "2018-05-31 17:00:00 America/Los_Angeles" {
+		t.Fatalf("Wrong expires_date: %s, %s, %s", latest.ExpiresDate, latest.ExpiresDateMS, latest.ExpiresDatePST)
+	}
+
+	if len(res.PendingRenewalInfo) != 1 || res.PendingRenewalInfo[0].AutoRenewStatus != "1" {
+		t.Fatalf("Wrong pending_renewal_info: %+v", res.PendingRenewalInfo)
+	}
+
+	res = Response{}
+	if _, err := Encode([]byte(receiptJSON), privKey, cert, WithResponse(&res)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Receipt.InApp) != 3 || res.LatestReceiptInfo != nil || res.PendingRenewalInfo != nil {
+		t.Fatalf("Receipt without subscriptions should not have latest_receipt_info: %+v", res)
+	}
+}
+
+func TestLatestReceiptInfoOrder(t *testing.T) {
+	purchaseDate := Date{time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)}
+	expiresDate := Date{time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)}
+
+	r := &Receipt{
+		InApp: []*InApp{
+			{ProductID: "jp.aktsk.kalvados.test.monthly", TransactionID: "10", OriginalTransactionID: "10", PurchaseDate: purchaseDate, ExpiresDate: expiresDate},
+			{ProductID: "jp.aktsk.kalvados.test.weekly", TransactionID: "9", OriginalTransactionID: "9", PurchaseDate: purchaseDate, ExpiresDate: expiresDate},
+		},
+	}
+
+	res := NewVerifyResponse(r, "", purchaseDate.Time)
+	if res.LatestReceiptInfo[0].TransactionID != "10" {
+		t.Fatalf("Transactions purchased at the same time should be in the numeric order of IDs: %s", res.LatestReceiptInfo[0].TransactionID)
+	}
+}
+
+func TestPendingRenewalInfoOfExpiredSubscription(t *testing.T) {
+	r := &Receipt{
+		InApp: []*InApp{
+			{
+				ProductID:             "jp.aktsk.kalvados.test.monthly",
+				TransactionID:         "1000000000000001",
+				OriginalTransactionID: "1000000000000001",
+				PurchaseDate:          Date{time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)},
+				ExpiresDate:           Date{time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)},
+				CancellationDate:      Date{time.Date(2018, 4, 15, 0, 0, 0, 0, time.UTC)},
+				CancellationReason:    "0",
+			},
+		},
+	}
+
+	res := NewVerifyResponse(r, "", time.Date(2018, 4, 20, 0, 0, 0, 0, time.UTC))
+	info := res.PendingRenewalInfo[0]
+	if info.AutoRenewStatus != "0" || info.ExpirationIntent != "" {
+		t.Fatalf("Refunded subscription should not be renewed, for an unknown reason: %+v", info)
+	}
+}
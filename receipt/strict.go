@@ -0,0 +1,187 @@
+package receipt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownField is returned in the strict mode when JSON receipt data
+// has fields kalvados does not know
+var ErrUnknownField = errors.New("unknown field")
+
+// Fields of JSON receipt data, and whether they are encoded in the
+// signed payload
+var (
+	receiptFields = map[string]bool{
+		"version":                      true,
+		"receipt_type":                 true,
+		"bundle_id":                    true,
+		"application_version":          true,
+		"original_application_version": true,
+		"receipt_creation_date":        true,
+		"original_purchase_date":       true,
+		"expiration_date":              true,
+		"opaque_value":                 true,
+		"sha1_hash":                    true,
+		"in_app":                       true,
+		"adam_id":                      false,
+		"app_item_id":                  false,
+		"download_id":                  false,
+		"version_external_identifier":  false,
+		"request_date":                 false,
+		"preorder_date":                false,
+	}
+
+	inAppFields = map[string]bool{
+		"quantity":                      true,
+		"product_id":                    true,
+		"transaction_id":                true,
+		"original_transaction_id":       true,
+		"purchase_date":                 true,
+		"original_purchase_date":        true,
+		"expires_date":                  true,
+		"web_order_line_item_id":        true,
+		"cancellation_date":             true,
+		"is_in_intro_offer_period":      true,
+		"is_trial_period":               false,
+		"is_upgraded":                   false,
+		"cancellation_reason":           false,
+		"promotional_offer_id":          false,
+		"offer_code_ref_name":           false,
+		"subscription_group_identifier": false,
+		"in_app_ownership_type":         false,
+	}
+
+	attributeListFields = map[string]bool{
+		"extra_attributes":    true,
+		"attribute_overrides": true,
+		"attribute_order":     true,
+	}
+
+	attributeObjectFields = map[string]bool{
+		"type":        true,
+		"version":     true,
+		"raw":         true,
+		"integer":     true,
+		"utf8_string": true,
+		"ia5_string":  true,
+		"remove":      true,
+	}
+)
+
+// WithStrict sets whether Encode returns ErrUnknownField when the JSON
+// receipt data has fields kalvados does not know. It is disabled by
+// default.
+func WithStrict(enabled bool) Option {
+	return func(o *options) {
+		o.strict = enabled
+	}
+}
+
+// WithWarnings sets warnings to the fields of the JSON receipt data which
+// are known but not reflected in the signed payload, as CheckFields
+// returns them. The fields are checked also when the strict mode is
+// disabled.
+func WithWarnings(warnings *[]string) Option {
+	return func(o *options) {
+		o.warnings = warnings
+	}
+}
+
+// CheckFields returns ErrUnknownField if receiptJSON has fields kalvados
+// does not know, like typos. Known fields which will not be reflected in
+// the signed payload, like adam_id, are returned as warnings.
+func CheckFields(receiptJSON []byte, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+
+	value, err := toJSONValue(json.RawMessage(receiptJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("JSON receipt data must be an object")
+	}
+
+	c := &fieldChecker{warnings: []string{}}
+
+	encoded := receiptFields
+	if !o.apple {
+		encoded = copyFields(receiptFields)
+		encoded["application_version"] = false
+	}
+
+	c.check("", doc, encoded)
+
+	inApps, _ := doc["in_app"].([]interface{})
+	for i, inApp := range inApps {
+		if m, ok := inApp.(map[string]interface{}); ok {
+			c.check(fmt.Sprintf("/in_app/%d", i), m, inAppFields)
+		}
+	}
+
+	if len(c.unknown) > 0 {
+		return c.warnings, fmt.Errorf("%s: %v", strings.Join(c.unknown, ", "), ErrUnknownField)
+	}
+
+	return c.warnings, nil
+}
+
+func copyFields(fields map[string]bool) map[string]bool {
+	copied := map[string]bool{}
+	for k, v := range fields {
+		copied[k] = v
+	}
+	return copied
+}
+
+type fieldChecker struct {
+	unknown  []string
+	warnings []string
+}
+
+// check checks the fields of an object at path, including its attributes
+func (c *fieldChecker) check(path string, m map[string]interface{}, fields map[string]bool) {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + strings.Replace(strings.Replace(k, "~", "~0", -1), "/", "~1", -1)
+
+		if attributeListFields[k] {
+			attributes, _ := m[k].([]interface{})
+			for i, a := range attributes {
+				if am, ok := a.(map[string]interface{}); ok {
+					c.check(fmt.Sprintf("%s/%d", p, i), am, attributeObjectFields)
+				}
+			}
+			continue
+		}
+
+		// The _ms and _pst variants of dates are in verifyReceipt
+		// responses, but only the dates are read
+		date := ""
+		for _, suffix := range []string{"_ms", "_pst"} {
+			if _, ok := fields[strings.TrimSuffix(k, suffix)]; ok && strings.HasSuffix(k, suffix) {
+				date = strings.TrimSuffix(k, suffix)
+			}
+		}
+
+		encoded, ok := fields[k]
+		switch {
+		case date != "":
+			c.warnings = append(c.warnings, fmt.Sprintf("%s is not reflected in the signed payload, %s is", p, date))
+		case !ok:
+			c.unknown = append(c.unknown, p)
+		case !encoded:
+			c.warnings = append(c.warnings, fmt.Sprintf("%s is not reflected in the signed payload", p))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package receipt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckFields(t *testing.T) {
+	warnings, err := CheckFields([]byte(receiptJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []string{"/adam_id", "/request_date", "/in_app/0/is_trial_period", "/in_app/0/purchase_date_ms"} {
+		found := false
+		for _, warning := range warnings {
+			found = found || strings.HasPrefix(warning, w+" ")
+		}
+		if !found {
+			t.Fatalf("%s should be warned: %v", w, warnings)
+		}
+	}
+
+	for _, warning := range warnings {
+		if strings.HasPrefix(warning, "/application_version ") || strings.HasPrefix(warning, "/in_app/0/purchase_date ") {
+			t.Fatalf("Wrong warning: %s", warning)
+		}
+	}
+
+	warnings, err = CheckFields([]byte(receiptJSON), WithAppleEncoding(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(warnings, "\n"), "/application_version ") {
+		t.Fatalf("application_version should be warned without Apple encoding: %v", warnings)
+	}
+
+	typoJSON := `{
+  "bundel_id": "jp.aktsk.kalvados.test",
+  "extra_attributes": [{"type": 1234, "utf8string": "typo"}],
+  "in_app": [{"prodcut_id": "jp.aktsk.kalvados.test.iap0", "purchase_date": "2018-01-01 00:00:00 Etc/GMT"}]
+}`
+
+	_, err = CheckFields([]byte(typoJSON))
+	if err == nil {
+		t.Fatal("Unknown fields should be an error")
+	}
+
+	for _, path := range []string{"/bundel_id", "/extra_attributes/0/utf8string", "/in_app/0/prodcut_id"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Fatalf("%s should be in the error: %v", path, err)
+		}
+	}
+
+	privKey, cert := generateKeyAndCert()
+
+	if _, err := Encode([]byte(typoJSON), privKey, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Encode([]byte(typoJSON), privKey, cert, WithStrict(true)); err == nil {
+		t.Fatal("Unknown fields should be an error in the strict mode")
+	}
+}
+
+func TestEncodeWithWarnings(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	var warnings []string
+	if _, err := Encode([]byte(receiptJSON), privKey, cert, WithWarnings(&warnings)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := CheckFields([]byte(receiptJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) == 0 || strings.Join(warnings, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Wrong warnings: %v", warnings)
+	}
+
+	warnings = nil
+	if _, err := Encode([]byte(`{"bundel_id": "typo", "adam_id": 0}`), privKey, cert, WithWarnings(&warnings)); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "/adam_id ") {
+		t.Fatalf("Wrong warnings without the strict mode: %v", warnings)
+	}
+}
@@ -0,0 +1,185 @@
+package receipt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Violation is a receipt that breaks an invariant of the App Store. Path
+// is a JSON Pointer to the field in the JSON receipt data.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+var (
+	receiptDateFields = []string{"receipt_creation_date", "request_date", "original_purchase_date", "expiration_date", "preorder_date"}
+	inAppDateFields   = []string{"purchase_date", "original_purchase_date", "expires_date", "cancellation_date"}
+)
+
+// Validate checks JSON receipt data against the invariants of receipts
+// of the App Store, and returns all the violations. Invalid dates are
+// violations too, and the other fields are checked without them. An
+// error is returned only if the JSON receipt data cannot be read.
+func Validate(receiptJSON []byte) ([]*Violation, error) {
+	value, err := toJSONValue(json.RawMessage(receiptJSON))
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("JSON receipt data is not an object")
+	}
+
+	v := &validator{violations: []*Violation{}}
+	invalid := map[string]bool{}
+
+	checkDates := func(m map[string]interface{}, path string, fields []string) {
+		for _, field := range fields {
+			date, ok := m[field]
+			if !ok {
+				continue
+			}
+			b, err := json.Marshal(date)
+			if err == nil {
+				err = (&Date{}).UnmarshalJSON(b)
+			}
+			if err != nil {
+				v.add(path+"/"+field, "%s is not a date: %s", field, b)
+				invalid[path+"/"+field] = true
+				delete(m, field)
+			}
+		}
+	}
+
+	checkDates(doc, "", receiptDateFields)
+	inApps, _ := doc["in_app"].([]interface{})
+	for i, inApp := range inApps {
+		if m, ok := inApp.(map[string]interface{}); ok {
+			checkDates(m, fmt.Sprintf("/in_app/%d", i), inAppDateFields)
+		}
+	}
+
+	receiptJSON, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	rcpt, err := UnmarshalReceipt(receiptJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	// A date which is not a date is reported once, not also as missing
+	for _, violation := range ValidateReceipt(rcpt) {
+		if !invalid[violation.Path] {
+			v.violations = append(v.violations, violation)
+		}
+	}
+
+	return v.violations, nil
+}
+
+// ValidateReceipt checks a receipt against the invariants of receipts of
+// the App Store
+func ValidateReceipt(r *Receipt) []*Violation {
+	v := &validator{violations: []*Violation{}}
+
+	creationDate := r.ReceiptCreationDate.Time
+	originalPurchaseDate := r.OriginalPurchaseDate.Time
+
+	if r.BundleID == "" {
+		v.add("/bundle_id", "bundle_id must not be empty")
+	}
+
+	if !creationDate.IsZero() && originalPurchaseDate.After(creationDate) {
+		v.add("/original_purchase_date", "original_purchase_date %s is after receipt_creation_date %s", originalPurchaseDate, creationDate)
+	}
+
+	transactions := map[string]*InApp{}
+	for i, inApp := range r.InApp {
+		path := fmt.Sprintf("/in_app/%d", i)
+
+		if inApp.TransactionID == "" {
+			v.add(path+"/transaction_id", "transaction_id must not be empty")
+		} else if _, ok := transactions[inApp.TransactionID]; ok {
+			v.add(path+"/transaction_id", "transaction_id %s is duplicated", inApp.TransactionID)
+		} else {
+			transactions[inApp.TransactionID] = inApp
+		}
+	}
+
+	for i, inApp := range r.InApp {
+		path := fmt.Sprintf("/in_app/%d", i)
+
+		purchaseDate := inApp.PurchaseDate.Time
+		inAppOriginalPurchaseDate := inApp.OriginalPurchaseDate.Time
+		expiresDate := inApp.ExpiresDate.Time
+		cancellationDate := inApp.CancellationDate.Time
+
+		if inApp.ProductID == "" {
+			v.add(path+"/product_id", "product_id must not be empty")
+		}
+
+		if inApp.Quantity < 1 {
+			v.add(path+"/quantity", "quantity %d must be at least 1", inApp.Quantity)
+		}
+
+		if purchaseDate.IsZero() {
+			v.add(path+"/purchase_date", "purchase_date must not be empty")
+			continue
+		}
+
+		if !creationDate.IsZero() && purchaseDate.After(creationDate) {
+			v.add(path+"/purchase_date", "purchase_date %s is after receipt_creation_date %s", purchaseDate, creationDate)
+		}
+
+		if inAppOriginalPurchaseDate.After(purchaseDate) {
+			v.add(path+"/original_purchase_date", "original_purchase_date %s is after purchase_date %s", inAppOriginalPurchaseDate, purchaseDate)
+		}
+
+		if !expiresDate.IsZero() && !expiresDate.After(purchaseDate) {
+			v.add(path+"/expires_date", "expires_date %s is not after purchase_date %s", expiresDate, purchaseDate)
+		}
+
+		if !cancellationDate.IsZero() && cancellationDate.Before(purchaseDate) {
+			v.add(path+"/cancellation_date", "cancellation_date %s is before purchase_date %s", cancellationDate, purchaseDate)
+		}
+
+		if inApp.OriginalTransactionID == "" || inApp.OriginalTransactionID == inApp.TransactionID {
+			continue
+		}
+
+		original, ok := transactions[inApp.OriginalTransactionID]
+		if !ok {
+			v.add(path+"/original_transaction_id", "original_transaction_id %s does not match any transaction", inApp.OriginalTransactionID)
+			continue
+		}
+
+		originalDate := original.PurchaseDate.Time
+		if originalDate.After(purchaseDate) {
+			v.add(path+"/original_transaction_id", "original transaction %s is purchased at %s, after purchase_date %s", inApp.OriginalTransactionID, originalDate, purchaseDate)
+		}
+
+		if !originalDate.IsZero() && !inAppOriginalPurchaseDate.Equal(originalDate) {
+			v.add(path+"/original_purchase_date", "original_purchase_date %s does not match purchase_date %s of the original transaction %s", inAppOriginalPurchaseDate, originalDate, inApp.OriginalTransactionID)
+		}
+	}
+
+	return v.violations
+}
+
+type validator struct {
+	violations []*Violation
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			args[i] = t.Format(time.RFC3339)
+		}
+	}
+	v.violations = append(v.violations, &Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
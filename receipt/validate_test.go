@@ -0,0 +1,124 @@
+package receipt
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	receiptJSON := `{
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "receipt_creation_date": "2018-03-01 00:00:00 Etc/GMT",
+  "original_purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+  "in_app": [
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000001",
+      "original_transaction_id": "1000000000000001",
+      "purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-02-01 00:00:00 Etc/GMT"
+    },
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000002",
+      "original_transaction_id": "1000000000000001",
+      "purchase_date": "2018-02-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-01-15 00:00:00 Etc/GMT"
+    },
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000002",
+      "original_transaction_id": "1000000000000009",
+      "purchase_date": "2018-04-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-01-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-05-01 00:00:00 Etc/GMT"
+    }
+  ]
+}`
+
+	violations, err := Validate([]byte(receiptJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"/in_app/1/expires_date":            true,
+		"/in_app/2/transaction_id":          true,
+		"/in_app/2/purchase_date":           true,
+		"/in_app/2/original_transaction_id": true,
+	}
+
+	if len(violations) != len(expected) {
+		t.Fatalf("Wrong violations: %d", len(violations))
+	}
+
+	for _, v := range violations {
+		if !expected[v.Path] {
+			t.Fatalf("Unexpected violation: %s: %s", v.Path, v.Message)
+		}
+	}
+
+}
+
+func TestValidateInvalidDates(t *testing.T) {
+	receiptJSON := `{
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "receipt_creation_date": "yesterday",
+  "in_app": [
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000001",
+      "purchase_date": "2018-01-01",
+      "expires_date": "2018-02-01 00:00:00 Etc/GMT"
+    },
+    {
+      "quantity": "0",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000002",
+      "purchase_date": "2018-02-01 00:00:00 Etc/GMT",
+      "cancellation_date": true
+    }
+  ]
+}`
+
+	violations, err := Validate([]byte(receiptJSON))
+	if err != nil {
+		t.Fatalf("Invalid dates should be violations: %v", err)
+	}
+
+	expected := map[string]bool{
+		"/receipt_creation_date":      true,
+		"/in_app/0/purchase_date":     true,
+		"/in_app/1/quantity":          true,
+		"/in_app/1/cancellation_date": true,
+	}
+
+	if len(violations) != len(expected) {
+		t.Fatalf("Wrong violations: %d", len(violations))
+	}
+
+	for _, v := range violations {
+		if !expected[v.Path] {
+			t.Fatalf("Unexpected violation: %s: %s", v.Path, v.Message)
+		}
+	}
+
+	if _, err := Validate([]byte(`[]`)); err == nil {
+		t.Fatal("JSON receipt data which is not an object should be an error")
+	}
+}
+
+func TestValidateRandomReceipts(t *testing.T) {
+	g := NewGenerator(1, DefaultConstraints())
+	for i := 0; i < 100; i++ {
+		rcpt := g.Receipt()
+		for _, v := range ValidateReceipt(rcpt) {
+			t.Fatalf("Random receipt should be valid: %s: %s", v.Path, v.Message)
+		}
+	}
+}
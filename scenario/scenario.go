@@ -0,0 +1,362 @@
+// Package scenario runs declarative scripts of purchases, renewals,
+// refunds and time advances against a mock App Store, and records the
+// receipts, verifyReceipt responses and notifications of each step.
+package scenario
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/aktsk/kalvados/appstore"
+	"github.com/aktsk/kalvados/clock"
+	"github.com/aktsk/kalvados/receipt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ErrInvalidStep is returned when a step does not have exactly one
+// action
+var ErrInvalidStep = errors.New("step must have exactly one of purchase, renew, advance, refund, revoke, auto_renew and billing")
+
+// ErrNotRenewing is returned by a renew step when the latest
+// subscription has expired, or is in billing retry period, so the clock
+// would have to go back to its expiration
+var ErrNotRenewing = errors.New("subscription does not renew, as it is not active")
+
+// latest refers to the latest transaction in refund and revoke steps
+const latest = "latest"
+
+// Scenario is a script of steps run against a mock App Store
+type Scenario struct {
+	BundleID string `json:"bundle_id"`
+
+	// Now is the time the scenario starts at in RFC 3339 format. It
+	// defaults to the current time.
+	Now string `json:"now,omitempty"`
+
+	Products []appstore.Product `json:"products"`
+	Steps    []Step             `json:"steps"`
+}
+
+// Step is a step of a scenario. It has one of the actions.
+type Step struct {
+	// Purchase purchases a product. Purchasing another product in the
+	// group of the active subscription upgrades, crossgrades or
+	// downgrades it, as appstore.Store.Purchase does. An upgrade
+	// cancels the current transaction with is_upgraded and starts a new
+	// one immediately.
+	Purchase string `json:"purchase,omitempty"`
+
+	// Renew advances the clock to the expiration of the latest
+	// subscription as many times as it is. The subscription must be
+	// active.
+	Renew int `json:"renew,omitempty"`
+
+	// Advance advances the clock by a duration like "72h", or by a
+	// period like "P1M"
+	Advance string `json:"advance,omitempty"`
+
+	// Refund and Revoke cancel a transaction by its ID, or the latest
+	// transaction by "latest"
+	Refund string `json:"refund,omitempty"`
+	Revoke string `json:"revoke,omitempty"`
+
+	// Reason is the cancellation reason of Refund and Revoke
+	Reason string `json:"reason,omitempty"`
+
+	// AutoRenew turns on or off auto-renew of the latest subscription
+	AutoRenew *bool `json:"auto_renew,omitempty"`
+
+	// Billing sets the billing state of the store
+	Billing *appstore.Billing `json:"billing,omitempty"`
+}
+
+// action returns the name of the action of the step
+func (st *Step) action() (string, error) {
+	actions := []string{}
+	if st.Purchase != "" {
+		actions = append(actions, "purchase")
+	}
+	if st.Renew > 0 {
+		actions = append(actions, "renew")
+	}
+	if st.Advance != "" {
+		actions = append(actions, "advance")
+	}
+	if st.Refund != "" {
+		actions = append(actions, "refund")
+	}
+	if st.Revoke != "" {
+		actions = append(actions, "revoke")
+	}
+	if st.AutoRenew != nil {
+		actions = append(actions, "auto_renew")
+	}
+	if st.Billing != nil {
+		actions = append(actions, "billing")
+	}
+
+	if len(actions) != 1 {
+		return "", ErrInvalidStep
+	}
+	return actions[0], nil
+}
+
+// Result is the result of a step
+type Result struct {
+	// Name is the prefix of the file names of the result like
+	// "001-purchase"
+	Name          string
+	ReceiptData   string
+	Response      *receipt.Response
+	Notifications []*appstore.Notification
+}
+
+// Parse parses a scenario in YAML or JSON
+func Parse(data []byte) (*Scenario, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	// Convert YAML to JSON to use JSON field names of products
+	j, err := json.Marshal(jsonValue(v))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scenario{}
+	if err := json.Unmarshal(j, s); err != nil {
+		return nil, err
+	}
+
+	for i := range s.Steps {
+		if _, err := s.Steps[i].action(); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+	}
+
+	return s, nil
+}
+
+// jsonValue converts a value decoded from YAML to the one which can be
+// encoded to JSON
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Run runs a scenario and returns the results of the steps
+func Run(s *Scenario, key *rsa.PrivateKey, cert *x509.Certificate) ([]*Result, error) {
+	now := time.Now()
+	if s.Now != "" {
+		t, err := time.Parse(time.RFC3339, s.Now)
+		if err != nil {
+			return nil, err
+		}
+		now = t
+	}
+
+	c := clock.New()
+	c.Freeze()
+	c.Set(now)
+
+	store := appstore.New(c)
+	for _, p := range s.Products {
+		if err := store.AddProduct(p); err != nil {
+			return nil, fmt.Errorf("%s: %v", p.ProductID, err)
+		}
+	}
+
+	r := &runner{scenario: s, store: store}
+
+	results := []*Result{}
+	for i := range s.Steps {
+		action, err := s.Steps[i].action()
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+
+		sent := len(store.Notifications())
+
+		if err := r.run(&s.Steps[i]); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+
+		result, err := r.result(key, cert)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+		result.Name = fmt.Sprintf("%03d-%s", i+1, action)
+		result.Notifications = store.Notifications()[sent:]
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+type runner struct {
+	scenario *Scenario
+	store    *appstore.Store
+
+	// subscription is the original_transaction_id of the latest
+	// subscription purchased
+	subscription string
+}
+
+func (r *runner) run(st *Step) error {
+	switch {
+	case st.Purchase != "":
+		t, err := r.store.Purchase(r.scenario.BundleID, st.Purchase)
+		if err != nil {
+			return err
+		}
+		if !t.ExpiresDate.IsZero() {
+			r.subscription = t.OriginalTransactionID
+		}
+	case st.Renew > 0:
+		for i := 0; i < st.Renew; i++ {
+			sub, ok := r.store.Subscription(r.subscription)
+			if !ok {
+				return appstore.ErrSubscriptionNotFound
+			}
+			if !sub.ExpiresDate.After(r.store.Clock.Now()) {
+				return ErrNotRenewing
+			}
+			r.store.Clock.Set(sub.ExpiresDate)
+			r.store.Update()
+		}
+	case st.Advance != "":
+		return r.advance(st.Advance)
+	case st.Refund != "":
+		id, err := r.transactionID(st.Refund)
+		if err != nil {
+			return err
+		}
+		_, err = r.store.Refund(id, st.Reason)
+		return err
+	case st.Revoke != "":
+		id, err := r.transactionID(st.Revoke)
+		if err != nil {
+			return err
+		}
+		_, err = r.store.Revoke(id, st.Reason)
+		return err
+	case st.AutoRenew != nil:
+		_, err := r.store.SetAutoRenew(r.subscription, *st.AutoRenew)
+		return err
+	case st.Billing != nil:
+		r.store.SetBilling(*st.Billing)
+	}
+
+	return nil
+}
+
+func (r *runner) advance(d string) error {
+	p := appstore.Period(d)
+	if p.Validate() == nil {
+		r.store.Clock.Set(p.AddTo(r.store.Clock.Now()))
+		r.store.Update()
+		return nil
+	}
+
+	duration, err := time.ParseDuration(d)
+	if err != nil {
+		return err
+	}
+
+	r.store.Clock.Advance(duration)
+	r.store.Update()
+
+	return nil
+}
+
+// transactionID returns the transaction ID referred by a refund or
+// revoke step
+func (r *runner) transactionID(id string) (string, error) {
+	if id != latest {
+		return id, nil
+	}
+
+	rcpt, err := r.store.Receipt(r.scenario.BundleID)
+	if err != nil {
+		return "", err
+	}
+	if len(rcpt.InApp) == 0 {
+		return "", appstore.ErrTransactionNotFound
+	}
+
+	return rcpt.InApp[len(rcpt.InApp)-1].TransactionID, nil
+}
+
+func (r *runner) result(key *rsa.PrivateKey, cert *x509.Certificate) (*Result, error) {
+	rcpt, err := r.store.Receipt(r.scenario.BundleID)
+	if err != nil {
+		return nil, err
+	}
+
+	receiptData, err := receipt.EncodeReceipt(rcpt, key, cert, receipt.WithNow(r.store.Clock.Now))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.store.Verify(rcpt)
+	if err != nil {
+		return nil, err
+	}
+	res.LatestReceipt = receiptData
+
+	return &Result{ReceiptData: receiptData, Response: res}, nil
+}
+
+// WriteResults writes the receipt, the verifyReceipt response and the
+// notifications of each step to a directory, like
+// 001-purchase-receipt.txt, 001-purchase-response.json and
+// 001-purchase-notifications.json.
+func WriteResults(dir string, results []*Result) error {
+	for _, result := range results {
+		prefix := filepath.Join(dir, result.Name)
+
+		if err := ioutil.WriteFile(prefix+"-receipt.txt", []byte(result.ReceiptData+"\n"), 0644); err != nil {
+			return err
+		}
+
+		if err := writeJSON(prefix+"-response.json", result.Response); err != nil {
+			return err
+		}
+
+		if err := writeJSON(prefix+"-notifications.json", result.Notifications); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(name, append(data, '\n'), 0644)
+}
@@ -0,0 +1,216 @@
+package scenario
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aktsk/kalvados/appstore"
+	"github.com/aktsk/kalvados/kalvadostest"
+)
+
+const testScenario = `
+bundle_id: com.example
+now: 2018-04-01T00:00:00Z
+products:
+  - product_id: com.example.monthly
+    subscription_period: P1M
+    subscription_group_id: premium
+    subscription_group_level: 2
+  - product_id: com.example.premium
+    subscription_period: P1M
+    subscription_group_id: premium
+    subscription_group_level: 1
+steps:
+  - purchase: com.example.monthly
+  - renew: 2
+  - purchase: com.example.premium
+  - advance: 72h
+  - refund: latest
+    reason: "1"
+`
+
+func TestRun(t *testing.T) {
+	privKey, cert, err := kalvadostest.GenerateKeyAndCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Parse([]byte(testScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Products[1].SubscriptionGroupLevel != 1 {
+		t.Fatalf("Wrong product: %+v", s.Products[1])
+	}
+
+	results, err := Run(s, privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"001-purchase", "002-renew", "003-purchase", "004-advance", "005-refund"}
+	if len(results) != len(names) {
+		t.Fatalf("Wrong number of results: %d", len(results))
+	}
+
+	types := [][]string{
+		{appstore.NotificationTypeInitialBuy},
+		{appstore.NotificationTypeDidRenew, appstore.NotificationTypeDidRenew},
+		{appstore.NotificationTypeInteractiveRenewal},
+		{},
+		{appstore.NotificationTypeRefund},
+	}
+
+	for i, result := range results {
+		if result.Name != names[i] {
+			t.Fatalf("Wrong name: %s", result.Name)
+		}
+
+		if len(result.Notifications) != len(types[i]) {
+			t.Fatalf("Wrong notifications of %s: %d", result.Name, len(result.Notifications))
+		}
+		for j, n := range result.Notifications {
+			if n.NotificationType != types[i][j] {
+				t.Fatalf("Wrong notification of %s: %s", result.Name, n.NotificationType)
+			}
+		}
+	}
+
+	inApp := results[4].Response.Receipt.InApp
+	if len(inApp) != 4 {
+		t.Fatalf("Wrong number of transactions: %d", len(inApp))
+	}
+
+	if inApp[2].IsUpgraded != "true" || inApp[3].ProductID != "com.example.premium" || inApp[3].CancellationReason != "1" {
+		t.Fatalf("Wrong transactions: %+v, %+v", inApp[2], inApp[3])
+	}
+
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := WriteResults(dir, results); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"001-purchase-receipt.txt", "003-purchase-response.json", "005-refund-notifications.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	privKey, cert, err := kalvadostest.GenerateKeyAndCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Parse([]byte(`
+bundle_id: com.example
+now: 2018-04-01T00:00:00Z
+products:
+  - product_id: com.example.basic
+    subscription_period: P1M
+    subscription_group_id: plans
+    subscription_group_level: 2
+  - product_id: com.example.premium
+    subscription_period: P1M
+    subscription_group_id: plans
+    subscription_group_level: 1
+steps:
+  - purchase: com.example.basic
+  - advance: 72h
+  - purchase: com.example.premium
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := Run(s, privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded := results[2]
+	if n := upgraded.Notifications; len(n) != 1 || n[0].NotificationType != appstore.NotificationTypeInteractiveRenewal {
+		t.Fatalf("Wrong notifications: %+v", n)
+	}
+
+	inApp := upgraded.Response.Receipt.InApp
+	if len(inApp) != 2 {
+		t.Fatalf("Wrong number of transactions: %d", len(inApp))
+	}
+
+	basic, premium := inApp[0], inApp[1]
+	if basic.ProductID != "com.example.basic" || basic.IsUpgraded != "true" || basic.CancellationDate != "2018-04-04 00:00:00 Etc/GMT" {
+		t.Fatalf("Upgraded transaction should be cancelled: %+v", basic)
+	}
+
+	if premium.ProductID != "com.example.premium" || premium.OriginalTransactionID != basic.OriginalTransactionID || premium.PurchaseDate != "2018-04-04 00:00:00 Etc/GMT" || premium.ExpiresDate != "2018-05-04 00:00:00 Etc/GMT" {
+		t.Fatalf("Upgrade should start a new transaction of the subscription: %+v", premium)
+	}
+
+	info := upgraded.Response.PendingRenewalInfo
+	if len(info) != 1 || info[0].AutoRenewProductID != "com.example.premium" {
+		t.Fatalf("Wrong pending_renewal_info: %+v", info)
+	}
+}
+
+func TestRenewAfterAdvance(t *testing.T) {
+	privKey, cert, err := kalvadostest.GenerateKeyAndCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Parse([]byte(`
+bundle_id: com.example
+now: 2018-04-01T00:00:00Z
+products:
+  - product_id: com.example.monthly
+    subscription_period: P1M
+steps:
+  - purchase: com.example.monthly
+  - advance: 72h
+  - renew: 1
+  - billing: {billing_issue: true}
+  - renew: 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := Run(s, privKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range map[int]string{2: "2018-05-01 00:00:00 Etc/GMT", 4: "2018-06-01 00:00:00 Etc/GMT"} {
+		if requestDate := results[i].Response.Receipt.RequestDate; requestDate != expected {
+			t.Fatalf("Renewal should advance the clock to %s: %s", expected, requestDate)
+		}
+	}
+
+	if n := results[4].Notifications; len(n) != 1 || n[0].NotificationType != appstore.NotificationTypeDidFailToRenew {
+		t.Fatalf("Wrong notifications: %+v", n)
+	}
+
+	// The subscription is in billing retry period, and renewing it would
+	// turn the clock back
+	s.Steps = append(s.Steps, Step{Renew: 1})
+	if _, err := Run(s, privKey, cert); err == nil || !strings.Contains(err.Error(), ErrNotRenewing.Error()) {
+		t.Fatalf("Subscription which is not active should not be renewed: %v", err)
+	}
+}
+
+func TestParseInvalidStep(t *testing.T) {
+	if _, err := Parse([]byte("steps:\n  - purchase: a\n    advance: 1h\n")); err == nil {
+		t.Fatal("Step with two actions should be invalid")
+	}
+}
@@ -0,0 +1,526 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/aktsk/kalvados/appstore"
+	"github.com/aktsk/kalvados/receipt"
+	nreceipt "github.com/aktsk/nolmandy/receipt"
+)
+
+// VerifyRequest is for request to the mock verifyReceipt endpoint
+type VerifyRequest struct {
+	ReceiptData string `json:"receipt-data"`
+	Password    string `json:"password"`
+}
+
+// FinishRequest is for finishing a transaction
+type FinishRequest struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+// CancelRequest is for refunding or revoking a transaction
+type CancelRequest struct {
+	TransactionID      string `json:"transaction_id"`
+	CancellationReason string `json:"cancellation_reason"`
+}
+
+// PurchaseRequest is for purchasing a product
+type PurchaseRequest struct {
+	AccountID          string `json:"account_id,omitempty"`
+	BundleID           string `json:"bundle_id"`
+	ProductID          string `json:"product_id"`
+	PromotionalOfferID string `json:"promotional_offer_id,omitempty"`
+	OfferCodeRefName   string `json:"offer_code_ref_name,omitempty"`
+
+	// AskToBuy defers the purchase until it is approved
+	AskToBuy bool `json:"ask_to_buy,omitempty"`
+}
+
+// PurchaseResponse is for respond the transaction of a purchase and
+// the receipt which has it. A purchase with Ask to Buy has no
+// transaction until it is approved.
+type PurchaseResponse struct {
+	ReceiptData       string `json:"receipt-data"`
+	TransactionID     string `json:"transaction_id,omitempty"`
+	PendingPurchaseID string `json:"pending_purchase_id,omitempty"`
+}
+
+// PendingPurchaseRequest is for approving or declining a purchase with
+// Ask to Buy
+type PendingPurchaseRequest struct {
+	PendingPurchaseID string `json:"pending_purchase_id"`
+}
+
+// RevokeFamilySharingRequest is for removing a member from a family
+type RevokeFamilySharingRequest struct {
+	FamilyID  string `json:"family_id"`
+	AccountID string `json:"account_id"`
+}
+
+// PriceRequest is for changing the price of a product
+type PriceRequest struct {
+	ProductID string `json:"product_id"`
+	Price     string `json:"price"`
+}
+
+// PriceConsentRequest is for accepting or declining a price increase
+// of a subscription
+type PriceConsentRequest struct {
+	OriginalTransactionID string `json:"original_transaction_id"`
+	Consent               bool   `json:"consent"`
+}
+
+// AutoRenewRequest is for turning on or off auto-renew of a subscription
+type AutoRenewRequest struct {
+	OriginalTransactionID string `json:"original_transaction_id"`
+	AutoRenewStatus       bool   `json:"auto_renew_status"`
+}
+
+// Status codes of verifyReceipt
+// https://developer.apple.com/documentation/appstorereceipts/status
+const (
+	statusMalformedReceipt = 21002
+)
+
+func (s *Server) verifyReceipt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req VerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		writeJSON(w, receipt.Response{Status: statusMalformedReceipt})
+		return
+	}
+
+	rcpt, err := nreceipt.Parse(s.cert, req.ReceiptData)
+	if err != nil {
+		log.Print(err)
+		writeJSON(w, receipt.Response{Status: statusMalformedReceipt})
+		return
+	}
+
+	res, err := st.Verify(rcpt)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *Server) finish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req FinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := st.Finish(req.TransactionID); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) refund(w http.ResponseWriter, r *http.Request) {
+	s.cancel(w, r, (*appstore.Store).Refund)
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	s.cancel(w, r, (*appstore.Store).Revoke)
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request, cancel func(*appstore.Store, string, string) (*appstore.Notification, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := cancel(st, req.TransactionID, req.CancellationReason)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	writeJSON(w, n)
+}
+
+func (s *Server) notifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, s.Store(r.URL.Query().Get("namespace")).Notifications())
+}
+
+func (s *Server) products(w http.ResponseWriter, r *http.Request) {
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var p appstore.Product
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := st.AddProduct(p); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), statusCode(err))
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, st.Products())
+}
+
+func (s *Server) purchase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req PurchaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := []appstore.PurchaseOption{appstore.WithAccount(req.AccountID)}
+	if req.PromotionalOfferID != "" {
+		opts = append(opts, appstore.WithPromotionalOffer(req.PromotionalOfferID))
+	}
+	if req.OfferCodeRefName != "" {
+		opts = append(opts, appstore.WithOfferCode(req.OfferCodeRefName))
+	}
+
+	var res PurchaseResponse
+	if req.AskToBuy {
+		pp, err := st.RequestPurchase(req.BundleID, req.ProductID, opts...)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), statusCode(err))
+			return
+		}
+		res.PendingPurchaseID = pp.ID
+	} else {
+		t, err := st.Purchase(req.BundleID, req.ProductID, opts...)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), statusCode(err))
+			return
+		}
+		res.TransactionID = t.TransactionID
+	}
+
+	receiptData, err := s.encodeStoreReceipt(st, req.AccountID, req.BundleID)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.ReceiptData = receiptData
+
+	writeJSON(w, res)
+}
+
+func (s *Server) pendingPurchases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	writeJSON(w, st.PendingPurchases())
+}
+
+func (s *Server) approve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req PendingPurchaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err := st.Approve(req.PendingPurchaseID)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	res, err := s.encodeStoreReceipt(st, t.AccountID, t.BundleID)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, PurchaseResponse{ReceiptData: res, TransactionID: t.TransactionID})
+}
+
+func (s *Server) decline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req PendingPurchaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := st.Decline(req.PendingPurchaseID); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	writeJSON(w, st.PendingPurchases())
+}
+
+func (s *Server) receipt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	res, err := s.encodeStoreReceipt(st, r.URL.Query().Get("account_id"), r.URL.Query().Get("bundle_id"))
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, Response{ReceiptData: res})
+}
+
+func (s *Server) encodeStoreReceipt(st *appstore.Store, accountID, bundleID string) (string, error) {
+	rcpt, err := st.ReceiptFor(accountID, bundleID)
+	if err != nil {
+		return "", err
+	}
+
+	return receipt.EncodeReceipt(rcpt, s.key, s.cert, receipt.WithNow(st.Clock.Now))
+}
+
+func (s *Server) autoRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req AutoRenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := st.SetAutoRenew(req.OriginalTransactionID, req.AutoRenewStatus)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	writeJSON(w, n)
+}
+
+func (s *Server) changePrice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req PriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notifications, err := st.ChangePrice(req.ProductID, req.Price)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	writeJSON(w, notifications)
+}
+
+func (s *Server) priceConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req PriceConsentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := st.SetPriceConsent(req.OriginalTransactionID, req.Consent)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	if n == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, n)
+}
+
+func (s *Server) billing(w http.ResponseWriter, r *http.Request) {
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var b appstore.Billing
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		st.SetBilling(b)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, st.Billing())
+}
+
+func (s *Server) families(w http.ResponseWriter, r *http.Request) {
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var f appstore.Family
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := st.AddFamily(f); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), statusCode(err))
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, st.Families())
+}
+
+func (s *Server) revokeFamilySharing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	var req RevokeFamilySharingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notifications, err := st.RevokeFamilySharing(req.FamilyID, req.AccountID)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+
+	writeJSON(w, notifications)
+}
+
+func statusCode(err error) int {
+	switch err {
+	case appstore.ErrTransactionNotFound, appstore.ErrProductNotFound, appstore.ErrSubscriptionNotFound, appstore.ErrOfferNotFound,
+		appstore.ErrFamilyNotFound, appstore.ErrNotFamilyMember, appstore.ErrPendingPurchaseNotFound:
+		return http.StatusNotFound
+	case appstore.ErrInvalidReason, appstore.ErrInvalidPeriod, appstore.ErrInvalidOffer, appstore.ErrInvalidFamily,
+		appstore.ErrInvalidPrice, appstore.ErrInvalidProductType, appstore.ErrInvalidGroupLevel:
+		return http.StatusBadRequest
+	case appstore.ErrNotEligible:
+		return http.StatusForbidden
+	case appstore.ErrAlreadySubscribed, appstore.ErrNoPriceIncrease:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
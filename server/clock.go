@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aktsk/kalvados/clock"
+)
+
+// ClockResponse is for respond the state of a virtual clock
+type ClockResponse struct {
+	Now    string `json:"now"`
+	NowMS  string `json:"now_ms"`
+	Frozen bool   `json:"frozen"`
+}
+
+// ClockRequest is for setting or advancing a virtual clock. Now is in
+// RFC 3339 format and Duration is in Go's duration format like "720h".
+type ClockRequest struct {
+	Now      string `json:"now"`
+	Duration string `json:"duration"`
+}
+
+func (s *Server) clockState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeClock(w, s.Clock(r.URL.Query().Get("namespace")))
+}
+
+func (s *Server) clockSet(w http.ResponseWriter, r *http.Request) {
+	req, ok := readClockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	now, err := time.Parse(time.RFC3339, req.Now)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := s.Clock(r.URL.Query().Get("namespace"))
+	c.Set(now)
+	s.update(r.URL.Query().Get("namespace"))
+
+	writeClock(w, c)
+}
+
+func (s *Server) clockAdvance(w http.ResponseWriter, r *http.Request) {
+	req, ok := readClockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := s.Clock(r.URL.Query().Get("namespace"))
+	c.Advance(d)
+	s.update(r.URL.Query().Get("namespace"))
+
+	writeClock(w, c)
+}
+
+func (s *Server) clockFreeze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := s.Clock(r.URL.Query().Get("namespace"))
+	c.Freeze()
+	s.update(r.URL.Query().Get("namespace"))
+
+	writeClock(w, c)
+}
+
+func (s *Server) clockUnfreeze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := s.Clock(r.URL.Query().Get("namespace"))
+	c.Unfreeze()
+	s.update(r.URL.Query().Get("namespace"))
+
+	writeClock(w, c)
+}
+
+func (s *Server) clockReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := s.Clock(r.URL.Query().Get("namespace"))
+	c.Reset()
+	s.update(r.URL.Query().Get("namespace"))
+
+	writeClock(w, c)
+}
+
+func readClockRequest(w http.ResponseWriter, r *http.Request) (ClockRequest, bool) {
+	var req ClockRequest
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+
+	return req, true
+}
+
+func writeClock(w http.ResponseWriter, c *clock.Clock) {
+	state := c.State()
+	writeJSON(w, ClockResponse{
+		Now:    state.Now.UTC().Format(time.RFC3339),
+		NowMS:  strconv.FormatInt(state.Now.UnixNano()/int64(time.Millisecond), 10),
+		Frozen: state.Frozen,
+	})
+}
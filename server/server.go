@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
@@ -8,22 +9,266 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/aktsk/kalvados/appstore"
+	"github.com/aktsk/kalvados/clock"
 	"github.com/aktsk/kalvados/receipt"
 )
 
-// Response is for respond base64 encoded receipt data
+// Response is for respond base64 encoded receipt data. Response is
+// the verifyReceipt style response for the receipt, which is set when
+// response=true is given. Warnings are the fields not reflected in the
+// signed payload, which are set in the strict mode.
 type Response struct {
-	ReceiptData string `json:"receipt-data"`
+	ReceiptData string            `json:"receipt-data"`
+	Response    *receipt.Response `json:"response,omitempty"`
+	Warnings    []string          `json:"warnings,omitempty"`
+}
+
+// Server is a receipt generator server. It also works as a mock App
+// Store which has a server-wide state and per-namespace states.
+type Server struct {
+	// NotificationURL is the URL notifications are posted to
+	NotificationURL string
+
+	// Strict sets whether JSON receipt data with unknown fields is
+	// rejected. strict=true or strict=false in the query overrides it.
+	Strict bool
+
+	key        *rsa.PrivateKey
+	cert       *x509.Certificate
+	store      *appstore.Store
+	mu         sync.Mutex
+	namespaces map[string]*appstore.Store
+	catalog    *appstore.Catalog
+	mux        *http.ServeMux
+	client     *http.Client
+}
+
+// notificationTimeout is the time limit for posting a notification
+const notificationTimeout = 10 * time.Second
+
+// New returns a receipt generator server
+func New(key *rsa.PrivateKey, cert *x509.Certificate) *Server {
+	s := &Server{
+		key:        key,
+		cert:       cert,
+		namespaces: map[string]*appstore.Store{},
+		mux:        http.NewServeMux(),
+		client:     &http.Client{Timeout: notificationTimeout},
+	}
+
+	s.store = s.newStore(clock.New())
+
+	s.mux.HandleFunc("/", s.encode)
+	s.mux.HandleFunc("/clock", s.clockState)
+	s.mux.HandleFunc("/clock/set", s.clockSet)
+	s.mux.HandleFunc("/clock/advance", s.clockAdvance)
+	s.mux.HandleFunc("/clock/freeze", s.clockFreeze)
+	s.mux.HandleFunc("/clock/unfreeze", s.clockUnfreeze)
+	s.mux.HandleFunc("/clock/reset", s.clockReset)
+	s.mux.HandleFunc("/verifyReceipt", s.verifyReceipt)
+	s.mux.HandleFunc("/finish", s.finish)
+	s.mux.HandleFunc("/refund", s.refund)
+	s.mux.HandleFunc("/revoke", s.revoke)
+	s.mux.HandleFunc("/notifications", s.notifications)
+	s.mux.HandleFunc("/products", s.products)
+	s.mux.HandleFunc("/products/price", s.changePrice)
+	s.mux.HandleFunc("/purchase", s.purchase)
+	s.mux.HandleFunc("/receipt", s.receipt)
+	s.mux.HandleFunc("/autoRenew", s.autoRenew)
+	s.mux.HandleFunc("/billing", s.billing)
+	s.mux.HandleFunc("/priceConsent", s.priceConsent)
+	s.mux.HandleFunc("/families", s.families)
+	s.mux.HandleFunc("/families/revoke", s.revokeFamilySharing)
+	s.mux.HandleFunc("/pendingPurchases", s.pendingPurchases)
+	s.mux.HandleFunc("/pendingPurchases/approve", s.approve)
+	s.mux.HandleFunc("/pendingPurchases/decline", s.decline)
+
+	return s
 }
 
 // Serve is for serving rceipt generator
 func Serve(port int, key *rsa.PrivateKey, cert *x509.Certificate) {
-	http.HandleFunc("/", Encode(key, cert))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), New(key, cert)))
 }
 
-// Encode encodes JSON receipt data
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Clock returns the virtual clock of a namespace. An empty namespace
+// means the server-wide clock.
+func (s *Server) Clock(namespace string) *clock.Clock {
+	return s.Store(namespace).Clock
+}
+
+// Store returns the mock App Store of a namespace. An empty namespace
+// means the server-wide store.
+func (s *Server) Store(namespace string) *appstore.Store {
+	if namespace == "" {
+		return s.store
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.namespaces[namespace]
+	if !ok {
+		st = s.newStore(clock.NewChild(s.store.Clock))
+		s.namespaces[namespace] = st
+	}
+
+	return st
+}
+
+// SetCatalog adds the products of a catalog to all the stores.
+// Receipts to encode are validated against the catalog.
+func (s *Server) SetCatalog(c *appstore.Catalog) error {
+	s.mu.Lock()
+	s.catalog = c
+	stores := []*appstore.Store{s.store}
+	for _, st := range s.namespaces {
+		stores = append(stores, st)
+	}
+	s.mu.Unlock()
+
+	for _, st := range stores {
+		if err := st.AddCatalog(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update brings the store of a namespace up to date with its clock.
+// Updating the server-wide store updates all the stores, as their
+// clocks may follow the server-wide clock.
+func (s *Server) update(namespace string) {
+	if namespace != "" {
+		s.Store(namespace).Update()
+		return
+	}
+
+	s.mu.Lock()
+	stores := []*appstore.Store{s.store}
+	for _, st := range s.namespaces {
+		stores = append(stores, st)
+	}
+	s.mu.Unlock()
+
+	for _, st := range stores {
+		st.Update()
+	}
+}
+
+func (s *Server) newStore(c *clock.Clock) *appstore.Store {
+	st := appstore.New(c)
+	st.Notify = s.postNotification
+	if s.catalog != nil {
+		// The catalog has been validated by SetCatalog
+		st.AddCatalog(s.catalog)
+	}
+	return st
+}
+
+func (s *Server) postNotification(n *appstore.Notification) {
+	if s.NotificationURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	resp, err := s.client.Post(s.NotificationURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (s *Server) encode(w http.ResponseWriter, r *http.Request) {
+	st := s.Store(r.URL.Query().Get("namespace"))
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	warnings, ok := checkFields(w, r, body, s.Strict)
+	if !ok {
+		return
+	}
+
+	rcpt, err := receipt.UnmarshalReceipt(body)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	nrcpt, err := rcpt.Nolmandy()
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	attributes, err := receipt.UnmarshalAttributes(body)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	catalog := s.catalog
+	s.mu.Unlock()
+
+	if catalog != nil {
+		if err := catalog.ValidateReceipt(nrcpt); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	st.Record(nrcpt)
+	if err := st.Apply(nrcpt); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rcpt.UpdateFromNolmandy(nrcpt)
+
+	opts := []receipt.Option{
+		receipt.WithNow(st.Clock.Now),
+		receipt.WithAttributes(attributes),
+	}
+	opts, response := withResponse(r, opts)
+
+	res, err := rcpt.Encode(s.key, s.cert, opts...)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, Response{ReceiptData: res, Response: response, Warnings: warnings})
+}
+
+// Encode encodes JSON receipt data. It is in the strict mode when
+// strict=true is given.
 func Encode(key *rsa.PrivateKey, cert *x509.Certificate) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(r.Body)
@@ -33,23 +278,65 @@ func Encode(key *rsa.PrivateKey, cert *x509.Certificate) func(http.ResponseWrite
 			return
 		}
 
-		res, err := receipt.Encode(body, key, cert)
-		if err != nil {
-			log.Print(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		warnings, ok := checkFields(w, r, body, false)
+		if !ok {
 			return
 		}
 
-		response := Response{ReceiptData: res}
+		opts, response := withResponse(r, nil)
 
-		responseBody, err := json.Marshal(response)
+		res, err := receipt.Encode(body, key, cert, opts...)
 		if err != nil {
 			log.Print(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(responseBody)
+		writeJSON(w, Response{ReceiptData: res, Response: response, Warnings: warnings})
+	}
+}
+
+// checkFields checks the fields of JSON receipt data in the strict mode,
+// which is strict unless the strict query parameter is given. It
+// returns the warnings, or false after responding with 400 for unknown
+// fields.
+func checkFields(w http.ResponseWriter, r *http.Request, body []byte, strict bool) ([]string, bool) {
+	if v := r.URL.Query().Get("strict"); v != "" {
+		strict = v == "true"
+	}
+	if !strict {
+		return nil, true
+	}
+
+	warnings, err := receipt.CheckFields(body)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return warnings, true
+}
+
+// withResponse adds receipt.WithResponse to opts when response=true is
+// given. The returned response is set by encoding the receipt.
+func withResponse(r *http.Request, opts []receipt.Option) ([]receipt.Option, *receipt.Response) {
+	if r.URL.Query().Get("response") != "true" {
+		return opts, nil
 	}
+
+	response := &receipt.Response{}
+	return append(opts, receipt.WithResponse(response)), response
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	responseBody, err := json.Marshal(v)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBody)
 }
@@ -11,6 +11,7 @@ import (
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
@@ -48,6 +49,379 @@ func TestServer(t *testing.T) {
 	}
 }
 
+func TestEncodeResponse(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	s := httptest.NewServer(New(privKey, cert))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/?response=true", "application/json", bytes.NewReader([]byte(receiptJSON)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Response == nil {
+		t.Fatal("Response should be returned")
+	}
+
+	if res.Response.Status != 0 {
+		t.Fatalf("Wrong status: %d", res.Response.Status)
+	}
+
+	if res.Response.LatestReceipt != res.ReceiptData {
+		t.Fatal("latest_receipt should be the encoded receipt")
+	}
+}
+
+func TestEncodeKeepsModelFields(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	s := httptest.NewServer(New(privKey, cert))
+	defer s.Close()
+
+	body := `{
+  "bundle_id": "jp.aktsk.kalvados.test",
+  "preorder_date": "2018-01-01 00:00:00 Etc/GMT",
+  "in_app": [
+    {
+      "quantity": "1",
+      "product_id": "jp.aktsk.kalvados.test.monthly",
+      "transaction_id": "1000000000000001",
+      "original_transaction_id": "1000000000000001",
+      "purchase_date": "2018-02-01 00:00:00 Etc/GMT",
+      "original_purchase_date": "2018-02-01 00:00:00 Etc/GMT",
+      "expires_date": "2018-03-01 00:00:00 Etc/GMT",
+      "is_upgraded": "true",
+      "subscription_group_identifier": "20000001",
+      "in_app_ownership_type": "FAMILY_SHARED"
+    }
+  ]
+}`
+
+	resp, err := http.Post(s.URL+"/?response=true&namespace=model", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Response == nil {
+		t.Fatal("Response should be returned")
+	}
+
+	if res.Response.Receipt.PreorderDate != "2018-01-01 00:00:00 Etc/GMT" {
+		t.Fatalf("preorder_date should be kept: %s", res.Response.Receipt.PreorderDate)
+	}
+
+	inApp := res.Response.Receipt.InApp[0]
+	if inApp.IsUpgraded != "true" || inApp.SubscriptionGroupIdentifier != "20000001" || inApp.InAppOwnershipType != "FAMILY_SHARED" {
+		t.Fatalf("Fields nolmandy's receipt does not have should be kept: %+v", inApp)
+	}
+}
+
+func TestEncodeStrict(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	ks := New(privKey, cert)
+	ks.Strict = true
+
+	s := httptest.NewServer(ks)
+	defer s.Close()
+
+	typo := `{"bundel_id": "jp.aktsk.kalvados.test"}`
+
+	for _, path := range []string{"/", "/?strict=true"} {
+		resp, err := http.Post(s.URL+path, "application/json", bytes.NewReader([]byte(typo)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Unknown fields should be rejected with %s: %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(s.URL+"/?strict=false", "application/json", bytes.NewReader([]byte(typo)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unknown fields should be accepted with strict=false: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(s.URL+"/?namespace=strict", "application/json", bytes.NewReader([]byte(receiptJSON)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Warnings) == 0 {
+		t.Fatal("Warnings should be returned in the strict mode")
+	}
+}
+
+func TestClock(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	s := httptest.NewServer(New(privKey, cert))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/clock/set?namespace=test", "application/json",
+		bytes.NewReader([]byte(`{"now": "2018-04-01T00:00:00Z"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Post(s.URL+"/clock/freeze?namespace=test", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Post(s.URL+"/clock/advance?namespace=test", "application/json",
+		bytes.NewReader([]byte(`{"duration": "24h"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state ClockResponse
+	json.NewDecoder(resp.Body).Decode(&state)
+	resp.Body.Close()
+
+	if state.Now != "2018-04-02T00:00:00Z" || !state.Frozen {
+		t.Fatalf("Wrong clock state: %v", state)
+	}
+
+	resp, err = http.Post(s.URL+"/?namespace=test", "application/json",
+		bytes.NewReader([]byte(`{"receipt_type": "ProductionSandbox"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rcpt server.Request
+	json.NewDecoder(resp.Body).Decode(&rcpt)
+	resp.Body.Close()
+
+	parsed, err := receipt.Parse(cert, rcpt.ReceiptData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creationDate := time.Time(parsed.CreationDate.Date)
+	if !creationDate.Equal(time.Date(2018, 4, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Wrong receipt_creation_date: %v", creationDate)
+	}
+
+	resp, err = http.Get(s.URL + "/clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	json.NewDecoder(resp.Body).Decode(&state)
+	resp.Body.Close()
+
+	if state.Frozen {
+		t.Fatal("Server-wide clock should not be frozen")
+	}
+}
+
+func TestRefund(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	s := httptest.NewServer(New(privKey, cert))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/?namespace=test", "application/json", bytes.NewReader([]byte(receiptJSON)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rcpt server.Request
+	json.NewDecoder(resp.Body).Decode(&rcpt)
+	resp.Body.Close()
+
+	resp, err = http.Post(s.URL+"/refund?namespace=test", "application/json",
+		bytes.NewReader([]byte(`{"transaction_id": "220000359893979", "cancellation_reason": "1"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Wrong status code: %d", resp.StatusCode)
+	}
+
+	reqBody, _ := json.Marshal(rcpt)
+	resp, err = http.Post(s.URL+"/verifyReceipt?namespace=test", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var verified struct {
+		Status  int `json:"status"`
+		Receipt struct {
+			InApp []map[string]string `json:"in_app"`
+		} `json:"receipt"`
+	}
+	json.NewDecoder(resp.Body).Decode(&verified)
+	resp.Body.Close()
+
+	if verified.Status != 0 || len(verified.Receipt.InApp) != 3 {
+		t.Fatalf("Wrong response: %v", verified)
+	}
+
+	if verified.Receipt.InApp[1]["cancellation_date"] == "" || verified.Receipt.InApp[1]["cancellation_reason"] != "1" {
+		t.Fatalf("Transaction is not refunded: %v", verified.Receipt.InApp[1])
+	}
+
+	resp, err = http.Get(s.URL + "/notifications?namespace=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notifications []map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&notifications)
+	resp.Body.Close()
+
+	if len(notifications) != 1 || notifications[0]["notification_type"] != "REFUND" {
+		t.Fatalf("Wrong notifications: %v", notifications)
+	}
+
+	resp, err = http.Post(s.URL+"/refund", "application/json",
+		bytes.NewReader([]byte(`{"transaction_id": "220000359893979"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Transactions in other namespaces should not be found: %d", resp.StatusCode)
+	}
+}
+
+func TestAddInvalidProduct(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	s := httptest.NewServer(New(privKey, cert))
+	defer s.Close()
+
+	products := []string{
+		`{"product_id": "jp.aktsk.kalvados.test.monthly", "subscription_period": "P1M", "subscription_group_id": "20000001"}`,
+		`{"product_id": "jp.aktsk.kalvados.test.coins", "type": "consumable", "family_shareable": true}`,
+	}
+
+	for _, p := range products {
+		resp, err := http.Post(s.URL+"/products", "application/json", bytes.NewReader([]byte(p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Invalid product should be a bad request: %d: %s", resp.StatusCode, p)
+		}
+	}
+}
+
+func TestBillingRetry(t *testing.T) {
+	privKey, cert := generateKeyAndCert()
+
+	var (
+		mu       sync.Mutex
+		notified []string
+	)
+	ns := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&n)
+
+		mu.Lock()
+		notified = append(notified, n["notification_type"].(string))
+		mu.Unlock()
+	}))
+	defer ns.Close()
+
+	ks := New(privKey, cert)
+	ks.NotificationURL = ns.URL
+
+	s := httptest.NewServer(ks)
+	defer s.Close()
+
+	requests := []struct {
+		path string
+		body string
+	}{
+		{"/clock/freeze", ``},
+		{"/clock/set", `{"now": "2018-04-01T00:00:00Z"}`},
+		{"/products", `{"product_id": "jp.aktsk.kalvados.test.monthly", "subscription_period": "P1M"}`},
+		{"/purchase", `{"bundle_id": "jp.aktsk.kalvados.test", "product_id": "jp.aktsk.kalvados.test.monthly"}`},
+		{"/billing", `{"billing_issue": true, "grace_period": true}`},
+		{"/clock/advance", `{"duration": "744h"}`},
+	}
+
+	var purchased PurchaseResponse
+	for _, req := range requests {
+		resp, err := http.Post(s.URL+req.path+"?namespace=test", "application/json", bytes.NewReader([]byte(req.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Wrong status code of %s: %d", req.path, resp.StatusCode)
+		}
+
+		if req.path == "/purchase" {
+			json.NewDecoder(resp.Body).Decode(&purchased)
+		}
+		resp.Body.Close()
+	}
+
+	mu.Lock()
+	if len(notified) != 2 || notified[1] != "DID_FAIL_TO_RENEW" {
+		t.Fatalf("Wrong notifications: %v", notified)
+	}
+	mu.Unlock()
+
+	reqBody, _ := json.Marshal(VerifyRequest{ReceiptData: purchased.ReceiptData})
+	resp, err := http.Post(s.URL+"/verifyReceipt?namespace=test", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var verified struct {
+		PendingRenewalInfo []map[string]string `json:"pending_renewal_info"`
+	}
+	json.NewDecoder(resp.Body).Decode(&verified)
+	resp.Body.Close()
+
+	if len(verified.PendingRenewalInfo) != 1 {
+		t.Fatalf("Wrong pending_renewal_info: %v", verified.PendingRenewalInfo)
+	}
+
+	info := verified.PendingRenewalInfo[0]
+	if info["original_transaction_id"] != purchased.TransactionID || info["is_in_billing_retry_period"] != "1" {
+		t.Fatalf("Wrong pending_renewal_info: %v", info)
+	}
+}
+
 func generateKeyAndCert() (*rsa.PrivateKey, *x509.Certificate) {
 	privKey, _ := rsa.GenerateKey(rand.Reader, 1024)
 